@@ -1,4 +1,4 @@
-module gosed
+module github.com/xplshn/gosed
 
 go 1.22.5
 
@@ -0,0 +1,276 @@
+// bench_test.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+package sed
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+// benchScripts are run over the benchmark input: the scripts sed is used for most, then scripts spending most of
+// their time jumping around.
+var benchScripts = []struct {
+	name, script string
+	lastLine     bool // the script uses $, which the list interpreter never matched
+}{
+	{"Print", "p", false},
+	{"Grep", "/lazy dog$/!d", false},
+	{"Delete", "/[02468]:/d", false},
+	{"Substitute", "s/fox/cat/g", false},
+	{"SubstituteGroups", "s/(quick) (brown)/${2} ${1}/", false},
+	{"SubstituteNth", "s/o/0/2", false},
+	{"NoMatch", "s/unicorn/horse/g", false},
+	{"RequiredLiteral", "/[0-9]+: the slow/d\ns/[a-z]+ unicorn/horse/g", false},
+	{"Transliterate", "y/abcdef/ABCDEF/", false},
+	{"LineNumbers", "=", false},
+	{"JoinLines", "$!N\nP\nD", true},
+	{"Hold", "h\nG", false},
+	{"Commands", "s/quick/slow/\ns/brown/red/\ns/lazy/busy/\ny/abc/ABC/\n/nothing/d", false},
+	{"Blocks", "/1$/{\n    s/fox/cat/\n    /3/{\n        p\n    }\n}\n/2$/{\n    s/dog/cow/\n}", false},
+	{"Branches", ":a\ns/o/0/\nta\n/5/b end\ns/e/3/g\n:end", false},
+	{"Dispatch", dispatchScript(), false},
+}
+
+// dispatchScript returns a script of many cheap commands that hardly ever run, so most of the time goes to
+// walking the script.
+func dispatchScript() string {
+	var buf bytes.Buffer
+	for i := 1; i <= 50; i++ {
+		fmt.Fprintf(&buf, "%d{\n    p\n}\n%dh\n:l%d\n", i*7, i*11, i)
+	}
+	return buf.String()
+}
+
+// benchInput returns about 4MB of lines to run the scripts over.
+func benchInput() []byte {
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < 4<<20; i++ {
+		buf.WriteString("line ")
+		buf.WriteString(strconv.Itoa(i))
+		buf.WriteString(": the quick brown fox jumps over the lazy dog\n")
+	}
+	return buf.Bytes()
+}
+
+// runListInterpreter runs the script the way process did before the script was compiled into a flat program: the
+// commands kept in a list, an interface type assertion for every address and command, and jumps going through
+// elements. The commands themselves are shared, so the difference is the dispatch alone.
+func (s *Sed) runListInterpreter() {
+	commands := list.New()
+	elements := make([]*list.Element, len(s.program))
+	for i, in := range s.program {
+		elements[i] = commands.PushBack(in.cmd)
+	}
+	defer s.flush()
+	for {
+		if s.restart {
+			s.restart = false
+		} else {
+			line, err := s.readLine(s.patternSpace[:0])
+			if err != nil {
+				break
+			}
+			s.patternSpace = line
+			s.lineNumber++
+		}
+		s.substituted = false
+		stop := false
+		for c := commands.Front(); c != nil; c = c.Next() {
+			cmd := c.Value.(Cmd)
+			if !cmd.getAddress().match(s.patternSpace, s.lineNumber) {
+				if block, ok := cmd.(*BlockCmd); ok {
+					c = elements[block.end]
+				}
+				continue
+			}
+			stop, _ = cmd.processLine(s)
+			if stop {
+				break
+			}
+			if s.jump >= 0 {
+				c = elements[s.jump]
+				s.jump = -1
+			}
+		}
+		if !s.quiet && !stop {
+			s.printPatternSpace()
+		}
+		s.flushAppendQueue()
+		if s.quit {
+			break
+		}
+	}
+}
+
+// benchmarkInterpreter runs every script of benchScripts over benchInput with run, the output going to /dev/null.
+// With list set, the scripts using $ are left out.
+func benchmarkInterpreter(b *testing.B, run func(s *Sed), list bool) {
+	input := benchInput()
+	out, err := os.Create(os.DevNull)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer out.Close()
+	for _, bench := range benchScripts {
+		if list && bench.lastLine {
+			continue
+		}
+		b.Run(bench.name, func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				s := new(Sed)
+				s.Init()
+				if err := s.parseScript([]byte(bench.script)); err != nil {
+					b.Fatal(err)
+				}
+				s.setOutput(out)
+				s.input = bufio.NewReader(bytes.NewReader(input))
+				run(s)
+			}
+		})
+	}
+}
+
+// BenchmarkProgram runs the scripts with process, on the flat instruction program. Run it with -benchmem or look at
+// allocs/op: a line that doesn't get substituted, transliterated or joined allocates nothing.
+func BenchmarkProgram(b *testing.B) {
+	benchmarkInterpreter(b, func(s *Sed) { s.runInput() }, false)
+}
+
+// BenchmarkChunked is BenchmarkProgram with the input split between every CPU for the scripts that allow it, as gosed
+// runs them unless --no-split is given.
+func BenchmarkChunked(b *testing.B) {
+	benchmarkInterpreter(b, func(s *Sed) {
+		if s.stateless() {
+			s.chunkWorkers = runtime.GOMAXPROCS(0)
+		}
+		s.runInput()
+	}, false)
+}
+
+// BenchmarkListInterpreter runs the same scripts with the dispatch loop the flat program replaced, to compare them with
+// benchstat or by eye.
+func BenchmarkListInterpreter(b *testing.B) {
+	benchmarkInterpreter(b, (*Sed).runListInterpreter, true)
+}
+
+// TestListInterpreter makes sure the two benchmarks are measuring the same work.
+func TestListInterpreter(t *testing.T) {
+	var input bytes.Buffer
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&input, "line %d: the quick brown fox jumps over the lazy dog\n", i)
+	}
+	for _, bench := range benchScripts {
+		if bench.lastLine {
+			continue
+		}
+		var outputs [2]string
+		for i, run := range []func(s *Sed){func(s *Sed) { s.runInput() }, (*Sed).runListInterpreter} {
+			s := new(Sed)
+			s.Init()
+			if err := s.parseScript([]byte(bench.script)); err != nil {
+				t.Fatal(err)
+			}
+			out, err := os.CreateTemp(t.TempDir(), "out")
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.setOutput(out)
+			s.input = bufio.NewReader(bytes.NewReader(input.Bytes()))
+			run(s)
+			out.Seek(0, 0)
+			b, _ := io.ReadAll(out)
+			out.Close()
+			outputs[i] = string(b)
+		}
+		checkString(t, bench.name, outputs[1], outputs[0])
+	}
+}
+
+// TestAllocations makes sure the scripts that don't substitute anything allocate nothing per line: running them over
+// ten times more input mustn't allocate more.
+func TestAllocations(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector allocates on its own")
+	}
+	out, err := os.Create(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	allocs := func(script string, lines int) float64 {
+		var input bytes.Buffer
+		for i := 0; i < lines; i++ {
+			fmt.Fprintf(&input, "line %d: the quick brown fox jumps over the lazy dog\n", i)
+		}
+		s := new(Sed)
+		s.Init()
+		if err := s.parseScript([]byte(script)); err != nil {
+			t.Fatal(err)
+		}
+		return testing.AllocsPerRun(1, func() {
+			s.lineNumber = 0
+			s.setOutput(out)
+			s.input = bufio.NewReader(bytes.NewReader(input.Bytes()))
+			s.runInput()
+		})
+	}
+	for _, script := range []string{"p", "/[02468]:/d", "s/unicorn/horse/g", "y/abc/ABC/", "=", "h\nG", "$!N\nP\nD", "/5/{\n    x\n    x\n}"} {
+		// A few allocations come and go with the pools of the regexp package, one per line would be thousands
+		if few, many := allocs(script, 1000), allocs(script, 10000); many > few+100 {
+			t.Errorf("%q: %v allocations for 1000 lines, %v for 10000", script, few, many)
+		}
+	}
+}
+
+// BenchmarkReplaceTable renames 500 identifiers, once with as many s commands and once with an m command.
+func BenchmarkReplaceTable(b *testing.B) {
+	var table, script bytes.Buffer
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&table, "identifier%d\trenamed%d\n", i, i)
+		fmt.Fprintf(&script, "s/identifier%d/renamed%d/g\n", i, i)
+	}
+	var input bytes.Buffer
+	for i := 0; input.Len() < 4<<20; i++ {
+		fmt.Fprintf(&input, "call(identifier%d, identifier%d) // the quick brown fox\n", i%700, i%300)
+	}
+	name := filepath.Join(b.TempDir(), "map.tsv")
+	if err := os.WriteFile(name, table.Bytes(), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	out, err := os.Create(os.DevNull)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer out.Close()
+	for _, bench := range []struct{ name, script string }{{"Substitutions", script.String()}, {"Table", "m " + name}} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.SetBytes(int64(input.Len()))
+			b.ReportAllocs()
+			s := new(Sed)
+			s.Init()
+			if err := s.parseScript([]byte(bench.script)); err != nil {
+				b.Fatal(err)
+			}
+			for i := 0; i < b.N; i++ {
+				s.lineNumber = 0
+				s.setOutput(out)
+				s.input = bufio.NewReader(bytes.NewReader(input.Bytes()))
+				s.runInput()
+			}
+		})
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 // Err definitions
@@ -25,17 +26,95 @@ var (
 	ErrUnterminatedRegularExpression  = errors.New("Unterminated regular expression")
 	ErrNoSupportForTwoAddress         = errors.New("This command doesn't support an address range or to end of file")
 	ErrNotImplemented                 = errors.New("This command command hasn't been implemented yet")
+	ErrPOSIXExtension                 = errors.New("Extension to POSIX sed rejected by --posix")
+	ErrMissingText                    = errors.New("Expected text after a, i or c command")
+	ErrMissingCommand                 = errors.New("Missing command")
+	ErrExtraCharacters                = errors.New("Extra characters after command")
+	ErrNoAddressAllowed               = errors.New("This command doesn't take an address")
+	ErrMissingLabel                   = errors.New("Expected a label after :")
+	ErrUnmatchedBrace                 = errors.New("Unmatched {")
+	ErrUnexpectedBrace                = errors.New("Unexpected }")
+	ErrUndefinedLabel                 = errors.New("Can't find label for jump")
+	ErrDuplicateLabel                 = errors.New("Label defined more than once")
+	ErrYLengthMismatch                = errors.New("Strings for y command are different lengths")
+	ErrMissingFileName                = errors.New("Expected a file name")
+	ErrReplaceTableLine               = errors.New("Expected old<TAB>new on every line of the replacement table")
+	ErrMissingRegister                = errors.New("Expected a register name of letters, digits and _ after :")
+	ErrMissingRangeEnd                = errors.New("Expected an address after ,")
+	ErrUnknownAddress                 = errors.New("Unknown named address, none was registered with this name")
+	ErrUnterminatedAddress            = errors.New("Unterminated {argument} of a named address")
 )
 
+// checkPOSIX returns an error naming the extension when s runs with --posix, nil otherwise. The extensions it lets
+// through are collected in s.extensions, so the linter can tell which commands aren't portable.
+func (s *Sed) checkPOSIX(extension string) error {
+	if s.posix {
+		return fmt.Errorf("%w: %s", ErrPOSIXExtension, extension)
+	}
+	s.extensions = append(s.extensions, extension)
+	return nil
+}
+
+// oneAddressCommands are the commands POSIX only gives a single address to.
+const oneAddressCommands = "aiqr="
+
+// checkOneAddress rejects an address range on a command that takes a single address. GNU accepts ranges
+// on all of them but q, so only --posix rejects them on the others.
+func (s *Sed) checkOneAddress(cmd byte, addr *address) error {
+	if !addr.isRange() || strings.IndexByte(oneAddressCommands, cmd) < 0 {
+		return nil
+	}
+	if cmd == 'q' || s.posix {
+		return fmt.Errorf("%w: %c", ErrNoSupportForTwoAddress, cmd)
+	}
+	return nil
+}
+
+// checkPOSIXRegex rejects, under --posix, what reads differently in the regular expressions of a POSIX sed, which are
+// BREs, than in gosed, which compiles them as EREs. That is the escapes Go's regexp understands but POSIX doesn't (the
+// only escaped letter a POSIX sed knows is \n, which matches the newline embedded in the pattern space), the ERE
+// operators + ? | ( ) and {n}, which are plain characters in a BRE, and the BRE operators \( \) \{ \}, which are plain
+// characters here. Bracket expressions are left alone, everything in them stands for itself in both.
+func (s *Sed) checkPOSIXRegex(r string) error {
+	for i := 0; i < len(r); i++ {
+		switch c := r[i]; {
+		case c == '[':
+			i = skipBracket([]byte(r), i) - 1
+		case c == '\\' && i+1 < len(r):
+			i++
+			switch c := r[i]; {
+			case c != 'n' && (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'):
+				return s.checkPOSIX(fmt.Sprintf("\\%c escape in regular expression", c))
+			case c == '(' || c == ')' || c == '{' || c == '}':
+				return s.checkPOSIX(fmt.Sprintf("\\%c in regular expression, a BRE operator in POSIX sed but a plain %c in gosed", c, c))
+			}
+		case c == '+' || c == '?' || c == '|' || c == '(' || c == ')' || c == '{' && i+1 < len(r) && isDigit(r[i+1]):
+			return s.checkPOSIX(fmt.Sprintf("%c in regular expression, an ERE operator in gosed but a plain %c in POSIX sed", c, c))
+		}
+	}
+	return nil
+}
+
 // Cmd represents a command that can be executed by the Sed processor // It includes methods for processing lines and converting the command to a string.
 type Cmd interface {
 	fmt.Stringer
+	source() string
+	getAddress() *address
 	processLine(s *Sed) (stop bool, err error)
 }
 
-// Address represents a pattern or address that can be matched against a line of input // It includes a method to determine if the address matches the given line and line number.
+// Address selects the lines a command runs on. Besides line numbers, $ and regular expressions, Go code can give
+// scripts addresses of its own with RegisterAddress. Match gets the current cycle, and must only read from it.
 type Address interface {
-	match(line []byte, lineNumber int) bool
+	Match(ctx *ExecContext) bool
+}
+
+// AddressFunc lets an ordinary function be an Address.
+type AddressFunc func(ctx *ExecContext) bool
+
+// Match calls f(ctx).
+func (f AddressFunc) Match(ctx *ExecContext) bool {
+	return f(ctx)
 }
 
 const (
@@ -44,6 +123,8 @@ const (
 	addressToEndOfFile
 	addressLastLine
 	addressRegEx
+	addressNamed // @name{argument}, an Address registered with RegisterAddress
+	addressSpan  // a range with a first or last address that isn't a line number
 )
 
 type address struct {
@@ -52,6 +133,10 @@ type address struct {
 	rangeStart  int
 	rangeEnd    int
 	regex       *regexp.Regexp
+	literal     regexLiteral // lets lines that can't match the regex skip it
+	named       Address      // what the Address registered for an @name address made of its argument
+	name        string       // an @name address as written
+	first, last *address     // the ends of a span
 }
 
 func (a *address) getTypeAsString() string {
@@ -67,6 +152,10 @@ func (a *address) getTypeAsString() string {
 			return "addressLastLine"
 		case addressRegEx:
 			return "addressRegEx"
+		case addressNamed:
+			return "addressNamed"
+		case addressSpan:
+			return "addressSpan"
 		default:
 			return "ADDRESS_UNKNOWN"
 		}
@@ -78,6 +167,44 @@ func (a *address) String() string {
 	return fmt.Sprintf("address{type: %s rangeStart:%d rangeEnd:%d regex:%v}", a.getTypeAsString(), a.rangeStart, a.rangeEnd, a.regex)
 }
 
+// source returns the address in canonical sed syntax, or nothing for a nil address.
+func (a *address) source() string {
+	if a == nil {
+		return ""
+	}
+	var src string
+	switch a.addressType {
+	case addressLine:
+		src = strconv.Itoa(a.rangeStart)
+	case addressRange:
+		src = fmt.Sprintf("%d,%d", a.rangeStart, a.rangeEnd)
+	case addressToEndOfFile:
+		src = fmt.Sprintf("%d,$", a.rangeStart)
+	case addressLastLine:
+		src = "$"
+	case addressRegEx:
+		src = "/" + escapeDelimiter(a.regex.String(), '/') + "/"
+	case addressNamed:
+		src = a.name
+	case addressSpan:
+		src = a.first.source() + "," + a.last.source()
+	}
+	if a.not {
+		src += "!"
+	}
+	return src
+}
+
+// isRange reports whether the address is made of two addresses.
+func (a *address) isRange() bool {
+	return a != nil && (a.addressType == addressRange || a.addressType == addressToEndOfFile || a.addressType == addressSpan)
+}
+
+// textSource returns an a, i or c command with its text in canonical sed syntax.
+func textSource(cmd byte, text []byte) string {
+	return string(cmd) + "\\\n" + strings.ReplaceAll(string(text), "\n", "\\\n")
+}
+
 func (a *address) match(line []byte, lineNumber int) bool {
 	val := true
 	if a != nil {
@@ -85,13 +212,14 @@ func (a *address) match(line []byte, lineNumber int) bool {
 		case addressLine:
 			val = lineNumber == a.rangeStart
 		case addressRange:
-			val = lineNumber >= a.rangeStart && lineNumber <= a.rangeEnd
+			// a range ending before it starts matches its first line only
+			val = lineNumber == a.rangeStart || lineNumber > a.rangeStart && lineNumber <= a.rangeEnd
 		case addressToEndOfFile:
 			val = lineNumber >= a.rangeStart
-		case addressLastLine:
-			val = false // this is wrong!
+		case addressLastLine, addressNamed, addressSpan:
+			val = false // only matches knows about the last line, runs named addresses and keeps track of spans
 		case addressRegEx:
-			val = a.regex.Match(line)
+			val = !a.literal.rejects(line) && (a.literal.pure || a.regex.Match(line))
 		default:
 			val = false
 		}
@@ -102,12 +230,23 @@ func (a *address) match(line []byte, lineNumber int) bool {
 	return val
 }
 
+// matches reports whether the address matches the current line of s. Unlike match it knows whether that line
+// is the last one, which $ needs, runs the Address of @name and moves in and out of spans.
+func (a *address) matches(s *Sed) bool {
+	switch a.addressType {
+	case addressLastLine:
+		return s.atEOF() != a.not
+	case addressNamed:
+		return a.named.Match(s.context()) != a.not
+	case addressSpan:
+		return s.inSpan(a) != a.not
+	}
+	return a.match(s.patternSpace, s.lineNumber)
+}
+
 func getNumberFromLine(s []byte) ([]byte, int, error) {
 	idx := 0
-	for {
-		if s[idx] < '0' || s[idx] > '9' {
-			break
-		}
+	for idx < len(s) && isDigit(s[idx]) {
 		idx++
 	}
 	i, err := strconv.Atoi(string(s[0:idx]))
@@ -117,69 +256,206 @@ func getNumberFromLine(s []byte) ([]byte, int, error) {
 	return s[idx:], i, nil
 }
 
-// A nil address means match any line
-func checkForAddress(s []byte) ([]byte, *address, error) {
+// checkForNot negates addr when the address is followed by a '!', and returns what follows.
+func checkForNot(s []byte, addr *address) []byte {
+	s = trimSpaceFromBeginning(s)
+	if len(s) > 0 && s[0] == '!' {
+		addr.not = true
+		s = s[1:]
+	}
+	return s
+}
+
+// checkForAddress parses the address line starts with, a single one or a range, and its '!', and returns what
+// follows it. A range of two line numbers, or of one and $, is worked out from the line number alone, the others
+// are spans, which keep track of whether they are open. A nil address means match any line.
+func checkForAddress(s *Sed, line []byte) ([]byte, *address, error) {
+	line, addr, err := parseAddress(s, line)
+	if err != nil || addr == nil {
+		return line, addr, err
+	}
+	if len(line) > 0 && line[0] == ',' {
+		var last *address
+		line, last, err = parseAddress(s, line[1:])
+		if err != nil {
+			return line, nil, err
+		}
+		switch {
+		case last == nil && addr.addressType == addressLine:
+			if err := s.checkPOSIX("address range without an end (N,)"); err != nil {
+				return line, nil, err
+			}
+			addr.addressType = addressToEndOfFile
+		case last == nil:
+			return line, nil, ErrMissingRangeEnd
+		case addr.addressType == addressLine && last.addressType == addressLine:
+			addr.addressType = addressRange
+			addr.rangeEnd = last.rangeStart
+		case addr.addressType == addressLine && last.addressType == addressLastLine:
+			addr.addressType = addressToEndOfFile
+		default:
+			addr = &address{addressType: addressSpan, first: addr, last: last}
+		}
+	}
+	return checkForNot(line, addr), addr, nil
+}
+
+// parseAddress parses the single address line starts with, and returns what follows it. The address is nil when line
+// doesn't start with one.
+func parseAddress(s *Sed, line []byte) ([]byte, *address, error) {
 	var err error
-	if s[0] == '/' {
+	if len(line) == 0 {
+		return line, nil, nil
+	}
+	if line[0] == '/' {
 		// regular expression address
-		s = s[1:]
-		idx := bytes.IndexByte(s, '/')
-		if idx < 0 {
-			return s, nil, ErrUnterminatedRegularExpression
+		end := skipDelimited(line, 1, '/', true)
+		if end < 0 {
+			return line, nil, ErrUnterminatedRegularExpression
 		}
-		r := s[0:idx]
+		r := unescapeDelimiter(line[1:end-1], '/')
 		if len(r) == 0 {
-			return s, nil, ErrRegularExpressionExpected
+			return line, nil, ErrRegularExpressionExpected
+		}
+		line = line[end:]
+		if err := s.checkPOSIXRegex(string(r)); err != nil {
+			return line, nil, err
 		}
-		// s is now just the command
-		s = s[idx+1:]
 		addr := new(address)
 		addr.addressType = addressRegEx
 		addr.regex, err = regexp.CompilePOSIX(string(r))
 		if err != nil {
-			return s, nil, err
+			return line, nil, err
 		}
-		return s, addr, nil
-	} else if s[0] == '$' {
+		addr.literal = analyzeRegex(string(r))
+		return line, addr, nil
+	} else if line[0] == '$' {
 		// end of file
 		addr := new(address)
 		addr.addressType = addressLastLine
-		// s is now just the command
-		s = s[1:]
-		return s, addr, nil
-	} else if s[0] >= '0' && s[0] <= '9' {
+		return line[1:], addr, nil
+	} else if line[0] >= '0' && line[0] <= '9' {
 		// numeric line address
 		addr := new(address)
 		addr.addressType = addressLine
-		s, addr.rangeStart, err = getNumberFromLine(s)
+		line, addr.rangeStart, err = getNumberFromLine(line)
 		if err != nil {
-			return s, nil, err
+			return line, nil, err
 		}
 		addr.rangeEnd = addr.rangeStart
-		if s[0] == ',' {
-			s = s[1:]
-			if len(s) > 0 && s[0] >= '0' && s[0] <= '9' {
-				addr.addressType = addressRange
-				s, addr.rangeEnd, err = getNumberFromLine(s)
-				if err != nil {
-					return s, nil, err
-				}
-				// if end range is less than start only match single line
-				if addr.rangeEnd < addr.rangeStart {
-					addr.addressType = addressLine
-					addr.rangeEnd = 0
-				}
-			} else {
-				addr.addressType = addressToEndOfFile
-			}
+		return line, addr, nil
+	} else if line[0] == '@' {
+		// named address, registered from Go
+		return parseNamedAddress(s, line)
+	}
+	return line, nil, nil
+}
+
+// parseNamedAddress parses the @name or @name{argument} address line starts with, running the parser registered for
+// name on the argument, and returns what follows it. A '}' in the argument is written \}.
+func parseNamedAddress(s *Sed, line []byte) ([]byte, *address, error) {
+	n := 1
+	for n < len(line) && isRegisterName(line[n]) {
+		n++
+	}
+	name := string(line[1:n])
+	parse := addressDefs[name]
+	if parse == nil {
+		return line, nil, fmt.Errorf("%w: @%s", ErrUnknownAddress, name)
+	}
+	var arg []byte
+	if n < len(line) && line[n] == '{' {
+		end := skipDelimited(line, n+1, '}', false)
+		if end < 0 {
+			return line, nil, ErrUnterminatedAddress
+		}
+		arg = unescapeDelimiter(line[n+1:end-1], '}')
+		n = end
+	}
+	if err := s.checkPOSIX("@" + name + " address"); err != nil {
+		return line, nil, err
+	}
+	named, err := parse(string(arg))
+	if err != nil {
+		return line, nil, fmt.Errorf("@%s: %w", name, err)
+	}
+	return line[n:], &address{addressType: addressNamed, named: named, name: string(line[:n])}, nil
+}
+
+// readText reads the text argument of the a, i and c commands. POSIX wants it on the lines following "a\",
+// every one but the last ending with a backslash. GNU also allows the first line on the same line as the
+// command ("a text" or "a\text"), which --posix rejects.
+func readText(s *Sed, line []byte) ([]byte, error) {
+	text := line[1:]
+	if len(text) > 0 && text[0] == '\\' && len(trimSpaceFromBeginning(text[1:])) == 0 {
+		next, err := s.getNextScriptLine()
+		if err != nil {
+			return nil, ErrMissingText
+		}
+		text = next
+	} else {
+		if err := s.checkPOSIX("text on the same line as the a, i or c command"); err != nil {
+			return nil, err
+		}
+		text = bytes.TrimPrefix(trimSpaceFromBeginning(text), []byte{'\\'})
+	}
+	text = copyByteSlice(text)
+	for bytes.HasSuffix(text, []byte{'\\'}) {
+		text = text[:len(text)-1]
+		next, err := s.getNextScriptLine()
+		if err != nil {
+			break
 		}
-		if s[0] == '!' {
-			addr.not = true
-			s = s[1:]
+		text = append(text, '\n')
+		text = append(text, next...)
+	}
+	return text, nil
+}
+
+// isCommandWord reports whether line starts with a command that takes no argument immediately followed by a
+// letter or digit, which makes it an unknown word rather than a known command.
+func isCommandWord(line []byte) bool {
+	switch line[0] {
+	case 'd', 'D', 'g', 'G', 'h', 'H', 'n', 'N', 'p', 'P', '=', 'x':
+		c := line[1]
+		return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+	}
+	return false
+}
+
+// isRegisterName reports whether c can be part of the name of a hold register.
+func isRegisterName(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || isDigit(c) || c == '_'
+}
+
+// parseRegister returns the named hold register of a g, G, h, H or x command, the NAME of h:NAME, and "" for the
+// hold space when the command has no name after it.
+func parseRegister(s *Sed, command []byte) (string, error) {
+	command = bytes.TrimRight(command, " \t")
+	if len(command) == 1 {
+		return "", nil
+	}
+	name := command[2:]
+	if command[1] != ':' || len(name) == 0 {
+		return "", ErrMissingRegister
+	}
+	for _, c := range name {
+		if !isRegisterName(c) {
+			return "", ErrMissingRegister
 		}
-		return s, addr, nil
 	}
-	return s, nil, nil
+	if err := s.checkPOSIX("named hold register"); err != nil {
+		return "", err
+	}
+	return string(name), nil
+}
+
+// registerSource returns the register of a g, G, h, H or x command as written after the command letter.
+func registerSource(register string) string {
+	if register == "" {
+		return ""
+	}
+	return ":" + register
 }
 
 // NewCmd creates a new Cmd instance based on the given Sed object and line of input.
@@ -198,41 +474,63 @@ func NewCmd(s *Sed, line []byte) (Cmd, error) {
 
 	var err error
 	var addr *address
-	line, addr, err = checkForAddress(line)
+	line, addr, err = checkForAddress(s, line)
 	if err != nil {
 		return nil, err
 	}
 
+	line = trimSpaceFromBeginning(line)
+	if def, _ := registeredCommand(line); def != nil {
+		return NewPluginCmd(s, def, line, addr)
+	}
+	if len(line) > 1 && isCommandWord(line) {
+		// Something like "x5o" is not an argument-less command followed by junk, it's no command at all
+		return nil, ErrUnknownScriptCommand
+	}
+
 	if len(line) > 0 {
+		if err := s.checkOneAddress(line[0], addr); err != nil {
+			return nil, err
+		}
 		switch line[0] {
 		case 'a':
 			return NewACmd(s, line, addr)
-		case 'b':
+		case 'b', 't':
 			return NewBCmd(bytes.Split(line, []byte{'/'}), addr)
+		case '{':
+			return NewBlockCmd(bytes.Split(line, []byte{'/'}), addr)
+		case '}':
+			return NewBlockEndCmd(bytes.Split(line, []byte{'/'}), addr)
+		case ':':
+			return NewLabelCmd(line, addr)
 		case 'c':
 			return NewCCmd(s, line, addr)
 		case 'd', 'D':
 			return NewDCmd(bytes.Split(line, []byte{'/'}), addr)
 		case 'g', 'G':
-			return NewGCmd(bytes.Split(line, []byte{'/'}), addr)
+			return NewGCmd(s, bytes.Split(line, []byte{'/'}), addr)
 		case 'h', 'H':
-			return NewHCmd(bytes.Split(line, []byte{'/'}), addr)
+			return NewHCmd(s, bytes.Split(line, []byte{'/'}), addr)
 		case 'i':
 			return NewICmd(s, line, addr)
-		case 'n':
+		case 'n', 'N':
 			return NewNCmd(bytes.Split(line, []byte{'/'}), addr)
 		case 'P', 'p':
 			return NewPCmd(bytes.Split(line, []byte{'/'}), addr)
 		case 'q':
-			return NewQCmd(bytes.Split(line, []byte{'/'}), addr)
+			return NewQCmd(s, bytes.Split(line, []byte{'/'}), addr)
+		case 'm':
+			return NewMCmd(s, line, addr)
 		case 'r':
 			return NewRCmd(line, addr)
 		case 's':
-			return NewSCmd(bytes.Split(line, []byte{'/'}), addr)
+			return NewSCmd(s, splitDelimited(line, 2, true), addr)
 		case '=':
 			return NewEqlCmd(bytes.Split(line, []byte{'/'}), addr)
 		case 'x':
-			return NewXCmd(bytes.Split(line, []byte{'/'}), addr)
+			return NewXCmd(s, bytes.Split(line, []byte{'/'}), addr)
+		case 'y':
+			return NewYCmd(s, splitDelimited(line, 2, false), addr)
 		}
 	}
 	return nil, ErrUnknownScriptCommand
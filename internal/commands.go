@@ -14,10 +14,11 @@ package sed
 import (
 	"bytes"
 	"fmt"
-	"io"
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 // Used in other parts of the `sed` package.
@@ -38,6 +39,11 @@ func (c *ACmd) match(line []byte, lineNumber int) bool {
 	return c.addr.match(line, lineNumber)
 }
 
+// getAddress returns the address of the ACmd, nil when it applies to every line.
+func (c *ACmd) getAddress() *address {
+	return c.addr
+}
+
 func (c *ACmd) String() string {
 	if c != nil {
 		if c.addr != nil {
@@ -48,37 +54,39 @@ func (c *ACmd) String() string {
 	return fmt.Sprintf("{a command}")
 }
 
-func (c *ACmd) processLine(_ *Sed) (bool, error) {
+// source returns the ACmd in canonical sed syntax.
+func (c *ACmd) source() string {
+	return c.addr.source() + textSource('a', c.text)
+}
+
+// processLine queues the text of the ACmd, to be written at the end of the cycle or when the next line is read.
+func (c *ACmd) processLine(s *Sed) (bool, error) {
+	s.appendQueue = append(s.appendQueue, c.text)
 	return false, nil
 }
 
 // NewACmd creates a new aCmd instance from the given Sed object, line, and address.
 func NewACmd(s *Sed, line []byte, addr *address) (*ACmd, error) {
+	var err error
 	cmd := new(ACmd)
 	cmd.addr = addr
-	cmd.text = line[1:]
-	for bytes.HasSuffix(cmd.text, []byte{'\\'}) {
-		cmd.text = cmd.text[0 : len(cmd.text)-1]
-		line, err := s.getNextScriptLine()
-		if err != nil {
-			break
-		}
-		buf := bytes.NewBuffer(cmd.text)
-		buf.WriteRune('\n')
-		buf.Write(line)
-		cmd.text = buf.Bytes()
+	cmd.text, err = readText(s, line)
+	if err != nil {
+		return nil, err
 	}
-	cmd.text = trimSpaceFromBeginning(cmd.text)
 	return cmd, nil
 }
 
 // E-OF: A_CMD //
 // B_CMD // As defined in: https://man.cat-v.org/unix_10th/1/sed#:~:text=(2)b%20label,the%20end%20of%20the%20script. // PERMALINK: https://web.archive.org/web/20240730163415/https://man.cat-v.org/unix_10th/1/sed#:~:text=(2)b%20label,the%20end%20of%20the%20script.
 
-// BCmd represents a 'b' command in sed, which branches to a specified label
+// BCmd represents a 'b' command in sed, which branches to a specified label, or a 't' command, which only
+// branches when a substitution was made since the last input line was read or the last 't' branched.
 type BCmd struct {
-	addr  *address
-	label string
+	addr        *address
+	label       string
+	target      int  // the index of the label to branch to, or of the last command of the script when there's no label
+	conditional bool // this is a 't' command
 }
 
 // match checks if the given line matches the address criteria of the bCmd.
@@ -86,21 +94,50 @@ func (c *BCmd) match(line []byte, lineNumber int) bool {
 	return c.addr.match(line, lineNumber)
 }
 
+// getAddress returns the address of the BCmd, nil when it applies to every line.
+func (c *BCmd) getAddress() *address {
+	return c.addr
+}
+
 // String returns a string representation of the BCmd, including its label and address
 func (c *BCmd) String() string {
 	if c != nil {
 		if c.addr != nil {
-			return fmt.Sprintf("{b command label: %s Cmd addr:%s}", c.label, c.addr.String())
+			return fmt.Sprintf("{%s command label: %s Cmd addr:%s}", c.name(), c.label, c.addr.String())
 		}
-		return fmt.Sprintf("{b command label: %s Cmd}", c.label)
+		return fmt.Sprintf("{%s command label: %s Cmd}", c.name(), c.label)
 	}
 	return fmt.Sprintf("{b command}")
 }
 
-// processLine processes the input line for the BCmd. It returns an error indicating the function is not implemented.
-func (c *BCmd) processLine(_ *Sed) (bool, error) {
-	return true, ErrNotImplemented
+// name returns the letter of the command, b or t.
+func (c *BCmd) name() string {
+	if c.conditional {
+		return "t"
+	}
+	return "b"
+}
+
+// source returns the BCmd in canonical sed syntax.
+func (c *BCmd) source() string {
+	if c.label != "" {
+		return c.addr.source() + c.name() + " " + c.label
+	}
+	return c.addr.source() + c.name()
+}
+
+// processLine processes the input line for the BCmd, telling sed to carry on after its target.
+func (c *BCmd) processLine(s *Sed) (bool, error) {
+	if c.conditional {
+		if !s.substituted {
+			return false, nil
+		}
+		s.substituted = false
+	}
+	s.jump = c.target
+	return false, nil
 }
+
 // NewBCmd creates a new BCmd instance from the given pieces of input and address
 func NewBCmd(pieces [][]byte, addr *address) (*BCmd, error) {
 	if len(pieces) != 1 {
@@ -108,11 +145,101 @@ func NewBCmd(pieces [][]byte, addr *address) (*BCmd, error) {
 	}
 	cmd := new(BCmd)
 	cmd.addr = addr
+	cmd.conditional = pieces[0][0] == 't'
 	cmd.label = string(bytes.TrimSpace(pieces[0][1:]))
 	return cmd, nil
 }
 
 // E-OF: B_CMD //
+// BLOCK_CMD //
+
+// BlockCmd represents a '{' in sed, which runs the commands up to the matching '}' only on the lines its address matches.
+type BlockCmd struct {
+	addr *address
+	end  int // the index of the matching '}', where the script carries on when the address doesn't match
+}
+
+// match checks if the given line matches the address criteria of the BlockCmd.
+func (c *BlockCmd) match(line []byte, lineNumber int) bool {
+	return c.addr.match(line, lineNumber)
+}
+
+// getAddress returns the address of the BlockCmd, nil when it applies to every line.
+func (c *BlockCmd) getAddress() *address {
+	return c.addr
+}
+
+// String returns a string representation of the BlockCmd, including its address.
+func (c *BlockCmd) String() string {
+	if c != nil && c.addr != nil {
+		return fmt.Sprintf("{{ command addr:%s}", c.addr.String())
+	}
+	return "{{ command}"
+}
+
+// source returns the BlockCmd in canonical sed syntax.
+func (c *BlockCmd) source() string {
+	if c.addr != nil {
+		return c.addr.source() + " {"
+	}
+	return "{"
+}
+
+// processLine does nothing, the commands of the block simply follow it.
+func (c *BlockCmd) processLine(_ *Sed) (bool, error) {
+	return false, nil
+}
+
+// NewBlockCmd creates a new BlockCmd instance from the given pieces of input and address.
+func NewBlockCmd(pieces [][]byte, addr *address) (*BlockCmd, error) {
+	if len(pieces) > 1 {
+		return nil, ErrWrongNumberOfCommandParameters
+	}
+	cmd := new(BlockCmd)
+	cmd.addr = addr
+	return cmd, nil
+}
+
+// BlockEndCmd represents the '}' closing a block.
+type BlockEndCmd struct{}
+
+// match always matches, a '}' can't have an address.
+func (c *BlockEndCmd) match(_ []byte, _ int) bool {
+	return true
+}
+
+// getAddress returns nil, a '}' can't have an address.
+func (c *BlockEndCmd) getAddress() *address {
+	return nil
+}
+
+// String returns a string representation of the BlockEndCmd.
+func (c *BlockEndCmd) String() string {
+	return "{} command}"
+}
+
+// source returns the BlockEndCmd in canonical sed syntax.
+func (c *BlockEndCmd) source() string {
+	return "}"
+}
+
+// processLine does nothing, it only marks where a block ends.
+func (c *BlockEndCmd) processLine(_ *Sed) (bool, error) {
+	return false, nil
+}
+
+// NewBlockEndCmd creates a new BlockEndCmd instance from the given pieces of input and address.
+func NewBlockEndCmd(pieces [][]byte, addr *address) (*BlockEndCmd, error) {
+	if addr != nil {
+		return nil, ErrNoAddressAllowed
+	}
+	if len(pieces) > 1 {
+		return nil, ErrWrongNumberOfCommandParameters
+	}
+	return new(BlockEndCmd), nil
+}
+
+// E-OF: BLOCK_CMD //
 // C_CMD // As defined in: https://man.cat-v.org/unix_10th/1/sed#:~:text=(2)c%5C,output.%20%20Start%20the%20next%20cycle. // PERMALINK: https://web.archive.org/web/20240730163415/https://man.cat-v.org/unix_10th/1/sed#:~:text=(2)c%5C,output.%20%20Start%20the%20next%20cycle.
 
 // CCmd represents a 'c' command in sed, which replaces lines that match the address with specified text.
@@ -126,6 +253,11 @@ func (c *CCmd) match(line []byte, lineNumber int) bool {
 	return c.addr.match(line, lineNumber)
 }
 
+// getAddress returns the address of the CCmd, nil when it applies to every line.
+func (c *CCmd) getAddress() *address {
+	return c.addr
+}
+
 // String returns a string representation of the CCmd, including its address and text.
 func (c *CCmd) String() string {
 	if c.addr != nil {
@@ -134,54 +266,49 @@ func (c *CCmd) String() string {
 	return fmt.Sprintf("{c command text:%s}", string(c.text))
 }
 
+// source returns the CCmd in canonical sed syntax.
+func (c *CCmd) source() string {
+	return c.addr.source() + textSource('c', c.text)
+}
+
 // printText writes the command's text to the output file.
 func (c *CCmd) printText(s *Sed) {
-	_, err := s.outputFile.Write(c.text)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing text: %v\n", err)
-	}
+	s.writeLine(c.text)
 }
 
-// processLine processes the input line for the CCmd, replacing the content based on the address.
+// processLine processes the input line for the CCmd, deleting the pattern space and printing the text instead.
+// On a range the text is printed once, at the end of the range.
 func (c *CCmd) processLine(s *Sed) (bool, error) {
 	s.patternSpace = s.patternSpace[:0]
-	if c.addr != nil {
+	if c.addr.isRange() && !c.addr.not {
 		switch c.addr.addressType {
 		case addressRange:
-			if s.lineNumber+1 == c.addr.rangeEnd {
-				c.printText(s)
+			if s.lineNumber < c.addr.rangeEnd {
 				return true, nil
 			}
-		case addressLine, addressRegEx, addressLastLine:
-			c.printText(s)
-			return true, nil
 		case addressToEndOfFile:
-			// Output at end of file is not handled here
-			fmt.Fprintln(os.Stderr, "TODO: Handle output at end of file")
+			if !s.atEOF() {
+				return true, nil
+			}
+		case addressSpan:
+			if s.spans[c.addr] {
+				return true, nil
+			}
 		}
-	} else {
-		c.printText(s)
-		return true, nil
 	}
-	return false, nil
+	c.printText(s)
+	return true, nil
 }
 
 // NewCCmd creates a new CCmd instance from the given Sed object, line of input, and address.
 func NewCCmd(s *Sed, line []byte, addr *address) (*CCmd, error) {
+	text, err := readText(s, line)
+	if err != nil {
+		return nil, err
+	}
 	cmd := &CCmd{
 		addr: addr,
-		text: line[1:],
-	}
-	for bytes.HasSuffix(cmd.text, []byte{'\\'}) {
-		cmd.text = cmd.text[:len(cmd.text)-1]
-		nextLine, err := s.getNextScriptLine()
-		if err != nil {
-			return nil, err
-		}
-		buf := bytes.NewBuffer(cmd.text)
-		buf.WriteRune('\n')
-		buf.Write(nextLine)
-		cmd.text = buf.Bytes()
+		text: text,
 	}
 	return cmd, nil
 }
@@ -200,6 +327,11 @@ func (c *DCmd) match(line []byte, lineNumber int) bool {
 	return c.addr.match(line, lineNumber)
 }
 
+// getAddress returns the address of the DCmd, nil when it applies to every line.
+func (c *DCmd) getAddress() *address {
+	return c.addr
+}
+
 // String returns a string representation of the DCmd, including its address and whether it deletes up to the first newline.
 func (c *DCmd) String() string {
 	if c.addr != nil {
@@ -214,12 +346,23 @@ func (c *DCmd) String() string {
 	return "{d command}"
 }
 
+// source returns the DCmd in canonical sed syntax.
+func (c *DCmd) source() string {
+	if c.upToFirstNewLine {
+		return c.addr.source() + "D"
+	}
+	return c.addr.source() + "d"
+}
+
 // processLine processes the input line for the DCmd, deleting the pattern space up to the first newline if specified.
 func (c *DCmd) processLine(s *Sed) (bool, error) {
 	if c.upToFirstNewLine {
 		idx := bytes.IndexByte(s.patternSpace, '\n')
-		if idx >= 0 && idx+1 < len(s.patternSpace) {
-			s.patternSpace = s.patternSpace[idx+1:]
+		if idx >= 0 {
+			// Start the next cycle with what is left instead of reading a new line. It is moved to the start of
+			// the buffer so that the buffer keeps its size and appending to it allocates nothing.
+			s.patternSpace = s.patternSpace[:copy(s.patternSpace, s.patternSpace[idx+1:])]
+			s.restart = true
 		} else {
 			s.patternSpace = s.patternSpace[:0] // Clear pattern space if newline is not found
 		}
@@ -254,6 +397,11 @@ func (c *EqlCmd) match(line []byte, lineNumber int) bool {
     return c.addr.match(line, lineNumber)
 }
 
+// getAddress returns the address of the EqlCmd, nil when it applies to every line.
+func (c *EqlCmd) getAddress() *address {
+    return c.addr
+}
+
 // String returns a string representation of the EqlCmd, including its address.
 func (c *EqlCmd) String() string {
     if c != nil && c.addr != nil {
@@ -262,9 +410,15 @@ func (c *EqlCmd) String() string {
     return fmt.Sprint("{= command}")
 }
 
+// source returns the EqlCmd in canonical sed syntax.
+func (c *EqlCmd) source() string {
+    return c.addr.source() + "="
+}
+
 // processLine processes the input line for the EqlCmd, printing the current line number.
 func (c *EqlCmd) processLine(s *Sed) (bool, error) {
-    fmt.Fprintf(os.Stdout, "\n%d\n", s.lineNumber)
+    s.scratch = strconv.AppendInt(s.scratch[:0], int64(s.lineNumber), 10)
+    s.writeLine(s.scratch)
     return false, nil
 }
 
@@ -283,8 +437,9 @@ func NewEqlCmd(pieces [][]byte, addr *address) (*EqlCmd, error) {
 
 // GCmd represents a 'g' command in sed, which replaces or appends the contents of the hold space to the pattern space.
 type GCmd struct {
-	addr    *address
-	replace bool
+	addr     *address
+	replace  bool
+	register string // the named hold register of g:NAME, "" for the hold space
 }
 
 // match checks if the given line matches the address criteria of the GCmd.
@@ -292,6 +447,11 @@ func (c *GCmd) match(line []byte, lineNumber int) bool {
     return c.addr.match(line, lineNumber)
 }
 
+// getAddress returns the address of the GCmd, nil when it applies to every line.
+func (c *GCmd) getAddress() *address {
+    return c.addr
+}
+
 // String returns a string representation of the GCmd, including its address and replace status.
 func (c *GCmd) String() string {
     if c != nil {
@@ -309,21 +469,28 @@ func (c *GCmd) String() string {
     return fmt.Sprint("{Append/Replace pattern space with contents of hold space}")
 }
 
+// source returns the GCmd in canonical sed syntax.
+func (c *GCmd) source() string {
+    if c.replace {
+        return c.addr.source() + "g" + registerSource(c.register)
+    }
+    return c.addr.source() + "G" + registerSource(c.register)
+}
+
 // processLine processes the input line for the GCmd, replacing or appending the hold space as specified.
 func (c *GCmd) processLine(s *Sed) (bool, error) {
+    hold := s.hold(c.register)
     if c.replace {
-        s.patternSpace = copyByteSlice(s.holdSpace)
+        s.patternSpace = append(s.patternSpace[:0], hold...)
     } else {
-        buf := bytes.NewBuffer(s.patternSpace)
-        buf.WriteRune('\n')
-        buf.Write(s.holdSpace)
-        s.patternSpace = buf.Bytes()
+        s.patternSpace = append(s.patternSpace, '\n')
+        s.patternSpace = append(s.patternSpace, hold...)
     }
     return false, nil
 }
 
 // NewGCmd creates a new GCmd instance from the given pieces of input and address.
-func NewGCmd(pieces [][]byte, addr *address) (*GCmd, error) {
+func NewGCmd(s *Sed, pieces [][]byte, addr *address) (*GCmd, error) {
     if len(pieces) > 1 {
         return nil, ErrWrongNumberOfCommandParameters
     }
@@ -331,6 +498,10 @@ func NewGCmd(pieces [][]byte, addr *address) (*GCmd, error) {
     if pieces[0][0] == 'g' {
         cmd.replace = true
     }
+    var err error
+    if cmd.register, err = parseRegister(s, pieces[0]); err != nil {
+        return nil, err
+    }
     cmd.addr = addr
     return cmd, nil
 }
@@ -340,8 +511,9 @@ func NewGCmd(pieces [][]byte, addr *address) (*GCmd, error) {
 
 // HCmd represents an 'h' command in sed, which replaces or appends the contents of the pattern space to the hold space.
 type HCmd struct {
-	addr    *address
-	replace bool
+	addr     *address
+	replace  bool
+	register string // the named hold register of h:NAME, "" for the hold space
 }
 
 // match checks if the given line matches the address criteria of the HCmd.
@@ -349,6 +521,11 @@ func (c *HCmd) match(line []byte, lineNumber int) bool {
 	return c.addr.match(line, lineNumber)
 }
 
+// getAddress returns the address of the HCmd, nil when it applies to every line.
+func (c *HCmd) getAddress() *address {
+	return c.addr
+}
+
 // String returns a string representation of the HCmd, including its address and replace status.
 func (c *HCmd) String() string {
 	if c != nil {
@@ -366,21 +543,29 @@ func (c *HCmd) String() string {
 	return fmt.Sprint("{h command}")
 }
 
+// source returns the HCmd in canonical sed syntax.
+func (c *HCmd) source() string {
+	if c.replace {
+		return c.addr.source() + "h" + registerSource(c.register)
+	}
+	return c.addr.source() + "H" + registerSource(c.register)
+}
+
 // processLine processes the input line for the HCmd, replacing or appending the pattern space as specified.
 func (c *HCmd) processLine(s *Sed) (bool, error) {
+	hold := s.hold(c.register)
 	if c.replace {
-		s.holdSpace = copyByteSlice(s.patternSpace)
+		hold = append(hold[:0], s.patternSpace...)
 	} else {
-		buf := bytes.NewBuffer(s.patternSpace)
-		buf.WriteRune('\n')
-		buf.Write(s.holdSpace)
-		s.patternSpace = buf.Bytes()
+		hold = append(hold, '\n')
+		hold = append(hold, s.patternSpace...)
 	}
+	s.setHold(c.register, hold)
 	return false, nil
 }
 
 // NewHCmd creates a new HCmd instance from the given pieces of input and address.
-func NewHCmd(pieces [][]byte, addr *address) (*HCmd, error) {
+func NewHCmd(s *Sed, pieces [][]byte, addr *address) (*HCmd, error) {
 	if len(pieces) > 1 {
 		return nil, ErrWrongNumberOfCommandParameters
 	}
@@ -388,6 +573,10 @@ func NewHCmd(pieces [][]byte, addr *address) (*HCmd, error) {
 	if pieces[0][0] == 'h' {
 		cmd.replace = true
 	}
+	var err error
+	if cmd.register, err = parseRegister(s, pieces[0]); err != nil {
+		return nil, err
+	}
 	cmd.addr = addr
 	return cmd, nil
 }
@@ -406,6 +595,11 @@ func (c *ICmd) match(line []byte, lineNumber int) bool {
 	return c.addr.match(line, lineNumber)
 }
 
+// getAddress returns the address of the ICmd, nil when it applies to every line.
+func (c *ICmd) getAddress() *address {
+	return c.addr
+}
+
 // String returns a string representation of the ICmd, including its address and text.
 func (c *ICmd) String() string {
 	if c != nil {
@@ -417,30 +611,154 @@ func (c *ICmd) String() string {
 	return fmt.Sprintf("{i command}")
 }
 
-// processLine processes the input line for the ICmd. It does not alter the pattern space.
-func (c *ICmd) processLine(_ *Sed) (bool, error) {
+// source returns the ICmd in canonical sed syntax.
+func (c *ICmd) source() string {
+	return c.addr.source() + textSource('i', c.text)
+}
+
+// processLine writes the text of the ICmd to the output right away. It does not alter the pattern space.
+func (c *ICmd) processLine(s *Sed) (bool, error) {
+	s.writeLine(c.text)
 	return false, nil
 }
 
 // NewICmd creates a new ICmd instance from the given line of input and address.
 func NewICmd(s *Sed, line []byte, addr *address) (*ICmd, error) {
+	var err error
 	cmd := new(ICmd)
 	cmd.addr = addr
-	cmd.text = line[1:]
-	for bytes.HasSuffix(cmd.text, []byte{'\\'}) {
-		cmd.text = cmd.text[0 : len(cmd.text)-1]
-		line, err := s.getNextScriptLine()
-		if err != nil {
-			break
-		}
-		buf := bytes.NewBuffer(cmd.text)
-		buf.Write(line)
-		s.patternSpace = buf.Bytes()
+	cmd.text, err = readText(s, line)
+	if err != nil {
+		return nil, err
 	}
 	return cmd, nil
 }
 
 // E-OF: I_CMD //
+// LABEL_CMD //
+
+// LabelCmd represents a ':' command in sed, which marks a place in the script that b commands can branch to.
+type LabelCmd struct {
+	label string
+}
+
+// match always matches, a label can't have an address.
+func (c *LabelCmd) match(_ []byte, _ int) bool {
+	return true
+}
+
+// getAddress returns nil, a label can't have an address.
+func (c *LabelCmd) getAddress() *address {
+	return nil
+}
+
+// String returns a string representation of the LabelCmd, including its label.
+func (c *LabelCmd) String() string {
+	if c != nil {
+		return fmt.Sprintf("{: command label: %s}", c.label)
+	}
+	return "{: command}"
+}
+
+// source returns the LabelCmd in canonical sed syntax.
+func (c *LabelCmd) source() string {
+	return ":" + c.label
+}
+
+// processLine does nothing, the label only marks a place in the script.
+func (c *LabelCmd) processLine(_ *Sed) (bool, error) {
+	return false, nil
+}
+
+// NewLabelCmd creates a new LabelCmd instance from the given line and address.
+func NewLabelCmd(line []byte, addr *address) (*LabelCmd, error) {
+	if addr != nil {
+		return nil, ErrNoAddressAllowed
+	}
+	cmd := new(LabelCmd)
+	cmd.label = string(bytes.TrimSpace(line[1:]))
+	if len(cmd.label) == 0 {
+		return nil, ErrMissingLabel
+	}
+	return cmd, nil
+}
+
+// E-OF: LABEL_CMD //
+// M_CMD // An extension: m file replaces, in a single pass, every old text of the old<TAB>new lines of file with its new text.
+
+// MCmd represents an 'm' command, which maps the pattern space through a table of literal replacements.
+type MCmd struct {
+	addr  *address
+	text  []byte // The name of the table file
+	table *replaceTable
+}
+
+// match checks if the given line matches the address criteria of the MCmd.
+func (c *MCmd) match(line []byte, lineNumber int) bool {
+	return c.addr.match(line, lineNumber)
+}
+
+// getAddress returns the address of the MCmd, nil when it applies to every line.
+func (c *MCmd) getAddress() *address {
+	return c.addr
+}
+
+// String returns a string representation of the MCmd, including its address and the table file.
+func (c *MCmd) String() string {
+	if c.addr != nil {
+		return fmt.Sprintf("{m command addr:%s table:%s}", c.addr.String(), c.fileName())
+	}
+	return fmt.Sprintf("{m command table:%s}", c.fileName())
+}
+
+// source returns the MCmd in canonical sed syntax.
+func (c *MCmd) source() string {
+	return c.addr.source() + "m " + c.fileName()
+}
+
+// fileName returns the name of the table file.
+func (c *MCmd) fileName() string {
+	return string(bytes.TrimSpace(c.text))
+}
+
+// processLine replaces what the table maps in the pattern space. Like s, a replacement lets the next t branch, and
+// every one is counted and told to the observers.
+func (c *MCmd) processLine(s *Sed) (bool, error) {
+	var each func(start int, old, replacement []byte)
+	if len(s.observers) > 0 {
+		each = s.substitution
+	}
+	out, replaced := c.table.replace(s.scratch[:0], s.patternSpace, each)
+	if replaced > 0 {
+		s.patternSpace, s.scratch = out, s.patternSpace[:0]
+		s.substituted = true
+		s.replacements += replaced
+	}
+	return false, nil
+}
+
+// NewMCmd creates a new MCmd instance from the given line and address, loading the table right away so that
+// a missing or malformed table is reported with the rest of the script errors.
+func NewMCmd(s *Sed, line []byte, addr *address) (*MCmd, error) {
+	if err := s.checkPOSIX("m command"); err != nil {
+		return nil, err
+	}
+	cmd := &MCmd{
+		addr: addr,
+		text: line[1:], // Remove the initial 'm' character
+	}
+	if cmd.fileName() == "" {
+		return nil, ErrMissingFileName
+	}
+	var err error
+	cmd.table, err = loadReplaceTable(cmd.fileName())
+	if err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// E-OF: M_CMD //
 // N_CMD // As defined in: https://man.cat-v.org/unix_10th/1/sed#:~:text=(2)n%20Copy%20the%20pattern%20space,%20%20%20%20%20%20%20%20%20%20%20%20%20%20%20changes.) // PERMALINK: https://web.archive.org/web/20240730163415/https://man.cat-v.org/unix_10th/1/sed#:~:text=(2)n%20Copy%20the%20pattern%20space,%20%20%20%20%20%20%20%20%20%20%20%20%20%20%20changes.)
 
 // NCmd represents an 'n' command in sed, which either prints the pattern space and replaces it with the next line ('n') or appends the next line of input to the pattern space ('N').
@@ -454,6 +772,11 @@ func (c *NCmd) match(line []byte, lineNumber int) bool {
 	return c.addr.match(line, lineNumber)
 }
 
+// getAddress returns the address of the NCmd, nil when it applies to every line.
+func (c *NCmd) getAddress() *address {
+	return c.addr
+}
+
 // String returns a string representation of the NCmd, including its address and whether it appends or replaces.
 func (c *NCmd) String() string {
 	if c != nil {
@@ -471,34 +794,45 @@ func (c *NCmd) String() string {
 	return fmt.Sprint("{n/N command}")
 }
 
+// source returns the NCmd in canonical sed syntax.
+func (c *NCmd) source() string {
+	if c.append {
+		return c.addr.source() + "N"
+	}
+	return c.addr.source() + "n"
+}
+
 // processLine processes the input line for the NCmd. It either prints the pattern space and replaces it with the next line or appends the next line to the pattern space.
+// Without a next line both quit without starting a new cycle. The pattern space is then printed as usual, except
+// for N under --posix, which quits without printing it.
 func (c *NCmd) processLine(s *Sed) (bool, error) {
+	if s.atEOF() {
+		if !s.quiet && !(c.append && s.posix) {
+			s.printPatternSpace()
+		}
+		s.quit = true
+		return true, nil
+	}
+	if !c.append && !s.quiet {
+		// n: Print the pattern space before replacing it
+		s.printPatternSpace()
+	}
+	s.flushAppendQueue()
+	var line []byte
+	var err error
 	if c.append {
 		// N: Append the next line of input to the pattern space
-		nextLine, err := s.input.ReadBytes('\n')
-		if err != nil {
-			if err == io.EOF {
-				return false, nil
-			}
-			return false, err
-		}
-		s.patternSpace = append(s.patternSpace, '\n')
-		s.patternSpace = append(s.patternSpace, nextLine...)
+		line, err = s.readLine(append(s.patternSpace, '\n'))
 	} else {
-		// n: Print and replace pattern space with the next line
-		if !*quiet {
-			s.printPatternSpace()
-		}
-		nextLine, err := s.input.ReadBytes('\n')
-		if err != nil {
-			if err == io.EOF {
-				return false, nil
-			}
-			return false, err
-		}
-		s.patternSpace = nextLine
+		// n: Replace the pattern space with the next line
+		line, err = s.readLine(s.patternSpace[:0])
 	}
-	return true, nil
+	if err != nil {
+		return false, err
+	}
+	s.lineNumber++
+	s.patternSpace = line
+	return false, nil
 }
 
 // NewNCmd creates a new NCmd instance from the given pieces and address.
@@ -527,6 +861,11 @@ func (c *PCmd) match(line []byte, lineNumber int) bool {
 	return c.addr.match(line, lineNumber)
 }
 
+// getAddress returns the address of the PCmd, nil when it applies to every line.
+func (c *PCmd) getAddress() *address {
+	return c.addr
+}
+
 // String returns a string representation of the PCmd, including its address and whether it prints up to a newline.
 func (c *PCmd) String() string {
 	if c != nil {
@@ -544,15 +883,28 @@ func (c *PCmd) String() string {
 	return fmt.Sprint("{p command}")
 }
 
+// source returns the PCmd in canonical sed syntax.
+func (c *PCmd) source() string {
+	if c.upToNewLine {
+		return c.addr.source() + "P"
+	}
+	return c.addr.source() + "p"
+}
+
 // processLine processes the pattern space for the PCmd. It either prints up to the first newline or the entire pattern space.
 func (c *PCmd) processLine(s *Sed) (bool, error) {
 	if c.upToNewLine {
 		// Print only up to the first newline
-		firstLine := bytes.SplitN(s.patternSpace, []byte{'\n'}, 2)[0]
-		fmt.Fprintln(s.outputFile, string(firstLine))
+		firstLine := s.patternSpace
+		if i := bytes.IndexByte(firstLine, '\n'); i >= 0 {
+			firstLine = firstLine[:i]
+		}
+		s.writeLine(firstLine)
+		s.printed(firstLine)
 	} else {
 		// Print the entire pattern space
-		fmt.Fprintln(s.outputFile, string(s.patternSpace))
+		s.printPatternSpace()
+		s.printed(s.patternSpace)
 	}
 	return false, nil
 }
@@ -583,6 +935,11 @@ func (c *QCmd) match(line []byte, lineNumber int) bool {
 	return c.addr.match(line, lineNumber)
 }
 
+// getAddress returns the address of the QCmd, nil when it applies to every line.
+func (c *QCmd) getAddress() *address {
+	return c.addr
+}
+
 // String returns a string representation of the QCmd, including its address and exit code.
 func (c *QCmd) String() string {
 	if c != nil {
@@ -594,40 +951,65 @@ func (c *QCmd) String() string {
 	return fmt.Sprint("{q command}")
 }
 
+// source returns the QCmd in canonical sed syntax.
+func (c *QCmd) source() string {
+	if c.exitCode != 0 {
+		return fmt.Sprintf("%sq %d", c.addr.source(), c.exitCode)
+	}
+	return c.addr.source() + "q"
+}
+
 // NewQCmd creates a new QCmd instance from the given pieces and address.
 // It parses the exit code if provided, or defaults to 0.
-func NewQCmd(pieces [][]byte, addr *address) (*QCmd, error) {
+func NewQCmd(s *Sed, pieces [][]byte, addr *address) (*QCmd, error) {
 	var err error
 	cmd := &QCmd{
 		addr: addr,
 	}
 	switch len(pieces) {
 	case 2:
+		if err := s.checkPOSIX("exit code for q"); err != nil {
+			return nil, err
+		}
 		cmd.exitCode, err = strconv.Atoi(string(pieces[1]))
 		if err != nil {
 			return nil, err
 		}
 	case 1:
-		cmd.exitCode = 0
+		// GNU style exit code: q5 or q 5
+		if code := bytes.TrimSpace(pieces[0][1:]); len(code) > 0 {
+			if err := s.checkPOSIX("exit code for q"); err != nil {
+				return nil, err
+			}
+			cmd.exitCode, err = strconv.Atoi(string(code))
+			if err != nil {
+				return nil, err
+			}
+		}
 	default:
 		return nil, ErrWrongNumberOfCommandParameters
 	}
 	return cmd, nil
 }
 
-// processLine terminates the sed process with the exit code specified in the QCmd.
-func (c *QCmd) processLine(_ *Sed) (bool, error) {
-	os.Exit(c.exitCode)
-	return false, nil
+// processLine ends the script, printing the pattern space unless -n is set, and tells sed to quit
+// with the exit code specified in the QCmd instead of starting a new cycle.
+func (c *QCmd) processLine(s *Sed) (bool, error) {
+	if !s.quiet {
+		s.printPatternSpace()
+	}
+	s.quit = true
+	s.exitCode = c.exitCode
+	return true, nil
 }
 
 // E-OF: Q_CMD //
 // R_CMD // As defined in: https://man.cat-v.org/unix_10th/1/sed#:~:text=(2)r%20rfile,reading%20the%20next%20input%20line. // PERMALINK: https://web.archive.org/web/20240730163415/https://man.cat-v.org/unix_10th/1/sed#:~:text=(2)r%20rfile,reading%20the%20next%20input%20line.
 
-// RCmd represents an 'r' command in sed, which reads a file and appends its contents to the pattern space.
+// RCmd represents an 'r' command in sed, which copies the contents of a file to the output at the end of the cycle.
 type RCmd struct {
 	addr *address
-	text []byte // Text to be written to the output file
+	text []byte // The name of the file to read
 }
 
 // match checks if the given line matches the address criteria of the RCmd.
@@ -635,6 +1017,11 @@ func (c *RCmd) match(line []byte, lineNumber int) bool {
 	return c.addr.match(line, lineNumber)
 }
 
+// getAddress returns the address of the RCmd, nil when it applies to every line.
+func (c *RCmd) getAddress() *address {
+	return c.addr
+}
+
 // String returns a string representation of the RCmd, including its address and the text.
 func (c *RCmd) String() string {
 	if c.addr != nil {
@@ -643,13 +1030,22 @@ func (c *RCmd) String() string {
 	return fmt.Sprint("{r command}")
 }
 
-// processLine writes the stored text to the output file if it exists.
+// source returns the RCmd in canonical sed syntax.
+func (c *RCmd) source() string {
+	return c.addr.source() + "r " + c.fileName()
+}
+
+// fileName returns the name of the file to read.
+func (c *RCmd) fileName() string {
+	return string(bytes.TrimSpace(c.text))
+}
+
+// processLine queues the contents of the file for the end of the cycle, like the text of an a command.
+// A file that can't be read is silently ignored.
 func (c *RCmd) processLine(s *Sed) (bool, error) {
-	if c.text != nil {
-		_, err := s.outputFile.Write(c.text)
-		if err != nil {
-			return false, err
-		}
+	contents, err := os.ReadFile(c.fileName())
+	if err == nil && len(contents) > 0 {
+		s.appendQueue = append(s.appendQueue, bytes.TrimSuffix(contents, newLine))
 	}
 	return false, nil
 }
@@ -679,6 +1075,8 @@ type SCmd struct {
 	replace      []byte
 	nthOccurance int
 	re           *regexp.Regexp
+	literal      regexLiteral // lets lines that can't match skip the regex, and literal patterns do without it
+	expands      bool         // the replacement refers to the match with $
 }
 
 // match checks if the given line matches the address criteria of the SCmd.
@@ -686,6 +1084,11 @@ func (c *SCmd) match(line []byte, lineNumber int) bool {
 	return c.addr.match(line, lineNumber)
 }
 
+// getAddress returns the address of the SCmd, nil when it applies to every line.
+func (c *SCmd) getAddress() *address {
+	return c.addr
+}
+
 // String returns a string representation of the SCmd, including its address, regex, replacement, and nth occurrence.
 func (c *SCmd) String() string {
 	if c.addr != nil {
@@ -694,8 +1097,19 @@ func (c *SCmd) String() string {
 	return fmt.Sprintf("{s command regex:%v replace:%s nth occurrence:%d}", c.regex, c.replace, c.nthOccurance)
 }
 
+// source returns the SCmd in canonical sed syntax.
+func (c *SCmd) source() string {
+	flags := ""
+	if c.nthOccurance == globalReplace {
+		flags = "g"
+	} else if c.nthOccurance > 1 {
+		flags = strconv.Itoa(c.nthOccurance)
+	}
+	return fmt.Sprintf("%ss/%s/%s/%s", c.addr.source(), escapeDelimiter(c.regex, '/'), escapeDelimiter(string(c.replace), '/'), flags)
+}
+
 // NewSCmd creates a new SCmd instance from the given pieces of input and address.
-func NewSCmd(pieces [][]byte, addr *address) (*SCmd, error) {
+func NewSCmd(s *Sed, pieces [][]byte, addr *address) (*SCmd, error) {
 	if len(pieces) != 4 {
 		return nil, ErrWrongNumberOfCommandParameters
 	}
@@ -710,11 +1124,17 @@ func NewSCmd(pieces [][]byte, addr *address) (*SCmd, error) {
 		return nil, ErrRegularExpressionExpected
 	}
 
+	if err := s.checkPOSIXRegex(cmd.regex); err != nil {
+		return nil, err
+	}
+
 	var err error
 	cmd.re, err = regexp.CompilePOSIX(cmd.regex)
 	if err != nil {
 		return nil, err
 	}
+	cmd.literal = analyzeRegex(cmd.regex)
+	cmd.expands = bytes.IndexByte(cmd.replace, '$') >= 0
 
 	flag := string(pieces[3])
 	if flag == "g" {
@@ -732,39 +1152,88 @@ func NewSCmd(pieces [][]byte, addr *address) (*SCmd, error) {
 	return cmd, nil
 }
 
+// find returns where the first match in line starts and ends, with bytes.Index when the pattern is a literal.
+func (c *SCmd) find(line []byte) (int, int, bool) {
+	if c.literal.pure {
+		i := bytes.Index(line, c.literal.required)
+		return i, i + len(c.literal.required), i >= 0
+	}
+	m := c.re.FindIndex(line)
+	if m == nil {
+		return 0, 0, false
+	}
+	return m[0], m[1], true
+}
+
 // processLine processes the input line for the SCmd, performing substitutions based on the regular expression.
 func (c *SCmd) processLine(s *Sed) (bool, error) {
-	if c.nthOccurance == globalReplace {
-		s.patternSpace = c.re.ReplaceAll(s.patternSpace, c.replace)
+	// The new pattern space is built in s.scratch, and the old one becomes the scratch buffer of the next s.
+	// A line without a match allocates nothing.
+	out := s.scratch[:0]
+	line := s.patternSpace
+	if c.literal.rejects(line) {
 		return false, nil
 	}
-
-	count := 0
-	line := s.patternSpace
-	s.patternSpace = make([]byte, 0)
-	for {
-		matches := c.re.FindIndex(line)
-		if len(matches) > 0 {
-			count++
+	switch {
+	case c.nthOccurance == globalReplace && c.literal.pure && !c.expands && s.confirmer == nil:
+		// Replacing a literal with a literal needs no regex at all
+		lit := c.literal.required
+		last := 0
+		for {
+			i := bytes.Index(line[last:], lit)
+			if i < 0 {
+				break
+			}
+			out = append(out, line[last:last+i]...)
+			out = append(out, c.replace...)
+			s.substitution(last+i, lit, c.replace)
+			last += i + len(lit)
+			s.replacements++
+		}
+		out = append(out, line[last:]...)
+	case c.nthOccurance == globalReplace:
+		// Every match is replaced on its own, with --confirm only the ones the user agrees to
+		last, replaced := 0, false
+		for _, m := range c.re.FindAllSubmatchIndex(line, -1) {
+			if !s.confirmed(c, out, line[last:], m[0]-last, m[1]-last) {
+				continue
+			}
+			out = append(out, line[last:m[0]]...)
+			n := len(out)
+			out = c.re.Expand(out, c.replace, line, m)
+			s.substitution(m[0], line[m[0]:m[1]], out[n:])
+			last, replaced = m[1], true
+			s.replacements++
+		}
+		if !replaced {
+			return false, nil
+		}
+		out = append(out, line[last:]...)
+	default:
+		for count := 1; ; count++ {
+			start, end, ok := c.find(line)
+			if !ok || start >= len(line) {
+				// Fewer matches than asked for, the pattern space stays as it is
+				return false, nil
+			}
 			if count == c.nthOccurance {
-				buf := bytes.NewBuffer(s.patternSpace)
-				buf.Write(line[:matches[0]])
-				buf.Write(c.replace)
-				buf.Write(line[matches[1]:])
-				s.patternSpace = buf.Bytes()
+				if !s.confirmed(c, out, line, start, end) {
+					return false, nil
+				}
+				// out holds what comes before line in the pattern space
+				s.substitution(len(out)+start, line[start:end], c.replace)
+				out = append(out, line[:start]...)
+				out = append(out, c.replace...)
+				out = append(out, line[end:]...)
+				s.replacements++
 				break
 			}
-			buf := bytes.NewBuffer(s.patternSpace)
-			buf.Write(line[:matches[0]+1])
-			s.patternSpace = buf.Bytes()
-			line = line[matches[0]+1:]
-			continue
+			out = append(out, line[:start+1]...)
+			line = line[start+1:]
 		}
-		buf := bytes.NewBuffer(s.patternSpace)
-		buf.Write(line)
-		s.patternSpace = buf.Bytes()
-		break
 	}
+	s.patternSpace, s.scratch = out, s.patternSpace[:0]
+	s.substituted = true
 	return false, nil
 }
 
@@ -773,7 +1242,8 @@ func (c *SCmd) processLine(s *Sed) (bool, error) {
 
 // XCmd represents an 'x' command in sed, which exchanges the contents of the pattern and hold spaces.
 type XCmd struct {
-	addr *address
+	addr     *address
+	register string // the named hold register of x:NAME, "" for the hold space
 }
 
 // match checks if the given line matches the address criteria of the XCmd.
@@ -781,6 +1251,11 @@ func (c *XCmd) match(line []byte, lineNumber int) bool {
 	return c.addr.match(line, lineNumber)
 }
 
+// getAddress returns the address of the XCmd, nil when it applies to every line.
+func (c *XCmd) getAddress() *address {
+	return c.addr
+}
+
 // String returns a string representation of the XCmd, including its address.
 func (c *XCmd) String() string {
 	if c != nil {
@@ -792,21 +1267,165 @@ func (c *XCmd) String() string {
 	return fmt.Sprintf("{x command}")
 }
 
+// source returns the XCmd in canonical sed syntax.
+func (c *XCmd) source() string {
+	return c.addr.source() + "x" + registerSource(c.register)
+}
+
 // processLine processes the input line for the XCmd, exchanging the contents of the pattern and hold spaces.
 func (c *XCmd) processLine(s *Sed) (bool, error) {
 	// Exchange the contents of the pattern space and hold space
-	s.patternSpace, s.holdSpace = s.holdSpace, s.patternSpace
+	hold := s.hold(c.register)
+	s.setHold(c.register, s.patternSpace)
+	s.patternSpace = hold
 	return false, nil
 }
 
 // NewXCmd creates a new XCmd instance from the given pieces of input and address.
-func NewXCmd(pieces [][]byte, addr *address) (*XCmd, error) {
+func NewXCmd(s *Sed, pieces [][]byte, addr *address) (*XCmd, error) {
 	if len(pieces) > 1 {
 		return nil, ErrWrongNumberOfCommandParameters
 	}
 	cmd := new(XCmd)
+	var err error
+	if cmd.register, err = parseRegister(s, pieces[0]); err != nil {
+		return nil, err
+	}
 	cmd.addr = addr
 	return cmd, nil
 }
 
 // E-OF: X_CMD //
+// Y_CMD //
+
+// YCmd represents a 'y' command in sed, which replaces every character of the pattern space found in one string
+// with the character at the same position in another.
+type YCmd struct {
+	addr *address
+	from []rune
+	to   []rune
+}
+
+// match checks if the given line matches the address criteria of the YCmd.
+func (c *YCmd) match(line []byte, lineNumber int) bool {
+	return c.addr.match(line, lineNumber)
+}
+
+// getAddress returns the address of the YCmd, nil when it applies to every line.
+func (c *YCmd) getAddress() *address {
+	return c.addr
+}
+
+// String returns a string representation of the YCmd, including its address and both strings.
+func (c *YCmd) String() string {
+	if c.addr != nil {
+		return fmt.Sprintf("{y command addr:%s from:%q to:%q}", c.addr.String(), string(c.from), string(c.to))
+	}
+	return fmt.Sprintf("{y command from:%q to:%q}", string(c.from), string(c.to))
+}
+
+// yEscape writes the characters of a y string back in script syntax.
+func yEscape(chars []rune) string {
+	var sb strings.Builder
+	for _, r := range chars {
+		switch r {
+		case '\n':
+			sb.WriteString("\\n")
+		case '\\':
+			sb.WriteString("\\\\")
+		case '/':
+			sb.WriteString("\\/")
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// source returns the YCmd in canonical sed syntax.
+func (c *YCmd) source() string {
+	return fmt.Sprintf("%sy/%s/%s/", c.addr.source(), yEscape(c.from), yEscape(c.to))
+}
+
+// processLine processes the input line for the YCmd, transliterating the pattern space.
+func (c *YCmd) processLine(s *Sed) (bool, error) {
+	out := s.scratch[:0]
+	for line := s.patternSpace; len(line) > 0; {
+		r, size := utf8.DecodeRune(line)
+		if r == utf8.RuneError && size == 1 {
+			// Not UTF-8, the byte is kept as it is
+			out = append(out, line[0])
+			line = line[1:]
+			continue
+		}
+		line = line[size:]
+		for i, from := range c.from {
+			if r == from {
+				r = c.to[i]
+				break
+			}
+		}
+		out = utf8.AppendRune(out, r)
+	}
+	s.patternSpace, s.scratch = out, s.patternSpace[:0]
+	return false, nil
+}
+
+// yUnescape turns the escapes of a y string into the characters they stand for. POSIX only defines \n and \\,
+// GNU also knows \t and \r, and lets any other escaped character stand for itself.
+func yUnescape(s *Sed, part []byte) ([]rune, error) {
+	var chars []rune
+	for i := 0; i < len(part); i++ {
+		if part[i] != '\\' || i+1 == len(part) {
+			r, size := utf8.DecodeRune(part[i:])
+			chars = append(chars, r)
+			i += size - 1
+			continue
+		}
+		i++
+		switch part[i] {
+		case 'n':
+			chars = append(chars, '\n')
+		case '\\':
+			chars = append(chars, '\\')
+		default:
+			if err := s.checkPOSIX(fmt.Sprintf("\\%c escape in y", part[i])); err != nil {
+				return nil, err
+			}
+			switch part[i] {
+			case 't':
+				chars = append(chars, '\t')
+			case 'r':
+				chars = append(chars, '\r')
+			default:
+				r, size := utf8.DecodeRune(part[i:])
+				chars = append(chars, r)
+				i += size - 1
+			}
+		}
+	}
+	return chars, nil
+}
+
+// NewYCmd creates a new YCmd instance from the given pieces of input and address.
+func NewYCmd(s *Sed, pieces [][]byte, addr *address) (*YCmd, error) {
+	if len(pieces) != 4 || len(bytes.TrimSpace(pieces[3])) > 0 {
+		return nil, ErrWrongNumberOfCommandParameters
+	}
+	cmd := new(YCmd)
+	cmd.addr = addr
+	var err error
+	if cmd.from, err = yUnescape(s, pieces[1]); err != nil {
+		return nil, err
+	}
+	if cmd.to, err = yUnescape(s, pieces[2]); err != nil {
+		return nil, err
+	}
+	if len(cmd.from) != len(cmd.to) {
+		// \n counts as a single character, which is easy to forget when lining the strings up
+		return nil, fmt.Errorf("%w: %d characters to replace, %d to replace them with", ErrYLengthMismatch, len(cmd.from), len(cmd.to))
+	}
+	return cmd, nil
+}
+
+// E-OF: Y_CMD //
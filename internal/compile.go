@@ -0,0 +1,578 @@
+// compile.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+
+// Package sed implements the entire program, from this specific part, we implement `gosed compile`, which turns a script into Go source
+package sed
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrCannotCompile is returned for the commands the compiler doesn't know how to turn into Go.
+var ErrCannotCompile = errors.New("This command can't be compiled to Go")
+
+// goRuntime is the part of the generated code every script needs: the state of a run and the input and output
+// helpers. PREFIX is replaced by the prefix of the package level names, so several scripts can share a package.
+const goRuntime = `
+// PREFIXState is the state of a run: the input and output, the pattern and hold space and the current line.
+type PREFIXState struct {
+	in             *bufio.Reader
+	out            *bufio.Writer
+	ps, hs         []byte
+	appendQueue    [][]byte
+	lineNumber     int
+	substituted    bool // an s command replaced something since the cycle started or t last branched
+	restart        bool // D asked for the next cycle to start without reading a new line
+	quit           bool
+	exitCode       int
+	missingNewline bool // the current line is the last one and has no newline
+	pendingNewline bool // the newline of the last output line was held back
+}
+
+// readLine reads the next line of input without its newline.
+func (st *PREFIXState) readLine() ([]byte, error) {
+	line, err := st.in.ReadBytes('\n')
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return nil, err
+	}
+	st.missingNewline = err == io.EOF
+	if !st.missingNewline {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
+// isLast reports whether the current line is the last one.
+func (st *PREFIXState) isLast() bool {
+	_, err := st.in.Peek(1)
+	return err != nil
+}
+
+func (st *PREFIXState) write(b []byte) {
+	if st.pendingNewline {
+		st.pendingNewline = false
+		st.out.WriteByte('\n')
+	}
+	st.out.Write(b)
+}
+
+func (st *PREFIXState) writeLine(b []byte) {
+	st.write(b)
+	st.out.WriteByte('\n')
+}
+
+// printPatternSpace prints the pattern space. A last line without a newline stays without one, unless something
+// else gets written after it.
+func (st *PREFIXState) printPatternSpace() {
+	st.write(st.ps)
+	if st.missingNewline {
+		st.pendingNewline = true
+		return
+	}
+	st.out.WriteByte('\n')
+}
+
+func (st *PREFIXState) flushAppendQueue() {
+	for _, text := range st.appendQueue {
+		st.writeLine(text)
+	}
+	st.appendQueue = st.appendQueue[:0]
+}
+`
+
+// goReplaceNth is the helper behind s commands replacing a single match.
+const goReplaceNth = `
+// PREFIXReplaceNth replaces the nth match of re in b with repl, and reports whether there was one.
+func PREFIXReplaceNth(re *regexp.Regexp, b, repl []byte, n int) ([]byte, bool) {
+	var out []byte
+	for count := 1; ; count++ {
+		loc := re.FindIndex(b)
+		if loc == nil || loc[0] >= len(b) {
+			return nil, false
+		}
+		if count == n {
+			out = append(out, b[:loc[0]]...)
+			out = append(out, repl...)
+			return append(out, b[loc[1]:]...), true
+		}
+		out = append(out, b[:loc[0]+1]...)
+		b = b[loc[0]+1:]
+	}
+}
+`
+
+// goInSpan is the helper behind span addresses, /begin/,/end/ and the like, which keep track of whether they are open.
+const goInSpan = `
+// PREFIXInSpan reports whether the current line is in a span, opening it when first matches and closing it when last
+// does. A last address that is a line number already reached (lastLine) makes a span of a single line, the others are
+// only tried from the line after the one that opened the span.
+func PREFIXInSpan(open *bool, first, last func() bool, lastLine bool) bool {
+	if *open {
+		*open = !last()
+		return true
+	}
+	if !first() {
+		return false
+	}
+	*open = !lastLine || !last()
+	return true
+}
+`
+
+// goCompiler turns a parsed script into a Go function. The commands become straight-line code: the blocks and
+// branches of the script are gotos to labels, all in the body of the loop running the cycles.
+type goCompiler struct {
+	s           *Sed
+	prefix      string // what the package level names of the generated code start with
+	quiet       bool
+	imports     map[string]bool
+	vars        strings.Builder // package level variables: the regexes and the replacements
+	regexes     map[string]string
+	varCount    int
+	body        strings.Builder
+	live        []bool         // the instructions that can run
+	fallThrough bool           // the end of the script can be reached without a branch
+	labels      map[int]string // the Go labels the gotos jump to, by the index of the instruction they are put before
+	endOfScript bool           // a branch jumps to the end of the script
+	endOfCycle  bool           // a command ends the cycle early
+	replaceNth  bool
+	exitCode    bool
+	spans       int // the span addresses, each open or not in an element of the spans array of the generated function
+}
+
+func newGoCompiler(s *Sed, funcName string, quiet bool) *goCompiler {
+	first, size := utf8.DecodeRuneInString(funcName)
+	return &goCompiler{
+		s:       s,
+		prefix:  string(unicode.ToLower(first)) + funcName[size:],
+		quiet:   quiet,
+		imports: map[string]bool{"bufio": true, "io": true},
+		regexes: make(map[string]string),
+	}
+}
+
+// jumpTargets names the instructions the live commands may jump to: the labels branches go to and the ends of the
+// blocks that have an address.
+func (g *goCompiler) jumpTargets(live []bool) map[int]string {
+	targets := make(map[int]string)
+	for i, in := range g.s.program {
+		if !live[i] {
+			continue
+		}
+		switch c := in.cmd.(type) {
+		case *BCmd:
+			if c.label != "" {
+				targets[c.target] = "label" + strconv.Itoa(c.target+1)
+			}
+		case *BlockCmd:
+			if c.addr != nil {
+				targets[c.end] = "block" + strconv.Itoa(i+1) + "End"
+			}
+		}
+	}
+	return targets
+}
+
+// findLiveCommands leaves out the commands following one that ends the cycle, up to a label some other live command
+// jumps to. Go rejects labels no goto uses, and go vet code that can never run, so neither may be generated.
+func (g *goCompiler) findLiveCommands() {
+	live := make([]bool, len(g.s.program))
+	for i := range live {
+		live[i] = true
+	}
+	count := len(live)
+	for {
+		targets := g.jumpTargets(live)
+		next := make([]bool, len(live))
+		nextCount := 0
+		reachable := true
+		for i, in := range g.s.program {
+			if _, ok := targets[i]; ok {
+				reachable = true
+			}
+			if !reachable {
+				continue
+			}
+			next[i] = true
+			nextCount++
+			if endsCycle(in.cmd) != "" {
+				reachable = false
+			}
+		}
+		g.fallThrough = reachable
+		// Dropping commands only ever drops targets, so this ends
+		if nextCount == count {
+			g.live, g.labels = live, targets
+			return
+		}
+		live, count = next, nextCount
+	}
+}
+
+// variable declares a package level variable holding value, and returns its name.
+func (g *goCompiler) variable(kind, value string) string {
+	name := fmt.Sprintf("%s%s%d", g.prefix, kind, g.varCount)
+	g.varCount++
+	fmt.Fprintf(&g.vars, "\t%s = %s\n", name, value)
+	return name
+}
+
+// regex returns the variable holding the compiled regex r.
+func (g *goCompiler) regex(r string) string {
+	if name, ok := g.regexes[r]; ok {
+		return name
+	}
+	g.imports["regexp"] = true
+	name := g.variable("Re", fmt.Sprintf("regexp.MustCompilePOSIX(%s)", strconv.Quote(r)))
+	g.regexes[r] = name
+	return name
+}
+
+// condition returns the Go expression telling whether addr matches the current line.
+func (g *goCompiler) condition(addr *address) string {
+	var cond string
+	switch addr.addressType {
+	case addressLine:
+		cond = fmt.Sprintf("st.lineNumber == %d", addr.rangeStart)
+	case addressRange:
+		cond = fmt.Sprintf("(st.lineNumber == %d || st.lineNumber > %d && st.lineNumber <= %d)", addr.rangeStart, addr.rangeStart, addr.rangeEnd)
+	case addressToEndOfFile:
+		cond = fmt.Sprintf("st.lineNumber >= %d", addr.rangeStart)
+	case addressLastLine:
+		cond = "st.isLast()"
+	case addressRegEx:
+		cond = g.regex(addr.regex.String()) + ".Match(st.ps)"
+	case addressSpan:
+		first, last := g.condition(addr.first), fmt.Sprintf("st.lineNumber >= %d", addr.last.rangeStart)
+		if addr.last.addressType != addressLine {
+			last = g.condition(addr.last)
+		}
+		cond = fmt.Sprintf("%sInSpan(&spans[%d], func() bool { return %s }, func() bool { return %s }, %t)", g.prefix, g.spans, first, last, addr.last.addressType == addressLine)
+		g.spans++
+	}
+	if addr.not {
+		return "!(" + cond + ")"
+	}
+	return cond
+}
+
+// goText returns the Go expression for the text of an a, i or c command.
+func goText(text []byte) string {
+	return "[]byte(" + strconv.Quote(string(text)) + ")"
+}
+
+// code returns the Go statements running c, without its address.
+func (g *goCompiler) code(c Cmd) (string, error) {
+	switch c := c.(type) {
+	case *BlockCmd, *BlockEndCmd, *LabelCmd:
+		return "", nil
+	case *BCmd:
+		target := "endOfScript"
+		if c.label != "" {
+			target = g.labels[c.target]
+		} else {
+			g.endOfScript = true
+		}
+		if c.conditional {
+			return fmt.Sprintf("if st.substituted {\nst.substituted = false\ngoto %s\n}\n", target), nil
+		}
+		return "goto " + target + "\n", nil
+	case *SCmd:
+		re := g.regex(c.regex)
+		replace := g.variable("Replace", "[]byte("+strconv.Quote(string(c.replace))+")")
+		if c.nthOccurance == globalReplace {
+			return fmt.Sprintf("if %s.Match(st.ps) {\nst.ps = %s.ReplaceAll(st.ps, %s)\nst.substituted = true\n}\n", re, re, replace), nil
+		}
+		g.replaceNth = true
+		return fmt.Sprintf("if out, ok := %sReplaceNth(%s, st.ps, %s, %d); ok {\nst.ps = out\nst.substituted = true\n}\n", g.prefix, re, replace, c.nthOccurance), nil
+	case *YCmd:
+		var cases strings.Builder
+		seen := make(map[rune]bool)
+		for i, r := range c.from {
+			if seen[r] {
+				continue
+			}
+			seen[r] = true
+			if r != c.to[i] {
+				fmt.Fprintf(&cases, "case %s:\nreturn %s\n", strconv.QuoteRune(r), strconv.QuoteRune(c.to[i]))
+			}
+		}
+		if cases.Len() == 0 {
+			return "", nil
+		}
+		g.imports["bytes"] = true
+		return fmt.Sprintf("st.ps = bytes.Map(func(r rune) rune {\nswitch r {\n%s}\nreturn r\n}, st.ps)\n", cases.String()), nil
+	case *PCmd:
+		if c.upToNewLine {
+			g.imports["bytes"] = true
+			return "{\nfirst, _, _ := bytes.Cut(st.ps, []byte{'\\n'})\nst.writeLine(first)\n}\n", nil
+		}
+		return "st.printPatternSpace()\n", nil
+	case *DCmd:
+		g.endOfCycle = true
+		if c.upToFirstNewLine {
+			g.imports["bytes"] = true
+			return "if i := bytes.IndexByte(st.ps, '\\n'); i >= 0 {\nst.ps = st.ps[i+1:]\nst.restart = true\n}\ngoto endOfCycle\n", nil
+		}
+		return "goto endOfCycle\n", nil
+	case *GCmd:
+		if c.register != "" {
+			// Named hold registers are left to gosed itself
+			break
+		}
+		if c.replace {
+			return "st.ps = append(st.ps[:0:0], st.hs...)\n", nil
+		}
+		return "st.ps = append(append(st.ps, '\\n'), st.hs...)\n", nil
+	case *HCmd:
+		if c.register != "" {
+			break
+		}
+		if c.replace {
+			return "st.hs = append(st.hs[:0:0], st.ps...)\n", nil
+		}
+		return "st.hs = append(append(st.hs, '\\n'), st.ps...)\n", nil
+	case *XCmd:
+		if c.register != "" {
+			break
+		}
+		return "st.ps, st.hs = st.hs, st.ps\n", nil
+	case *EqlCmd:
+		g.imports["strconv"] = true
+		return "st.writeLine([]byte(strconv.Itoa(st.lineNumber)))\n", nil
+	case *ACmd:
+		return "st.appendQueue = append(st.appendQueue, " + goText(c.text) + ")\n", nil
+	case *ICmd:
+		return "st.writeLine(" + goText(c.text) + ")\n", nil
+	case *CCmd:
+		g.endOfCycle = true
+		write := "st.writeLine(" + goText(c.text) + ")\n"
+		if c.addr.isRange() && !c.addr.not {
+			// On a range the text is printed once, at the end of the range
+			end := "st.isLast()"
+			if c.addr.addressType == addressRange {
+				end = fmt.Sprintf("st.lineNumber >= %d", c.addr.rangeEnd)
+			}
+			write = "if " + end + " {\n" + write + "}\n"
+		}
+		return write + "goto endOfCycle\n", nil
+	case *NCmd:
+		g.endOfCycle = true
+		var code strings.Builder
+		code.WriteString("if st.isLast() {\n")
+		if !g.quiet && !(c.append && g.s.posix) {
+			code.WriteString("st.printPatternSpace()\n")
+		}
+		code.WriteString("st.quit = true\ngoto endOfCycle\n}\n")
+		if !c.append && !g.quiet {
+			code.WriteString("st.printPatternSpace()\n")
+		}
+		code.WriteString("st.flushAppendQueue()\n{\nline, err := st.readLine()\nif err != nil {\nreturn err\n}\nst.lineNumber++\n")
+		if c.append {
+			code.WriteString("st.ps = append(append(st.ps, '\\n'), line...)\n}\n")
+		} else {
+			code.WriteString("st.ps = line\n}\n")
+		}
+		return code.String(), nil
+	case *QCmd:
+		g.endOfCycle = true
+		var code strings.Builder
+		if !g.quiet {
+			code.WriteString("st.printPatternSpace()\n")
+		}
+		code.WriteString("st.quit = true\n")
+		if c.exitCode != 0 {
+			g.exitCode = true
+			fmt.Fprintf(&code, "st.exitCode = %d\n", c.exitCode)
+		}
+		code.WriteString("goto endOfCycle\n")
+		return code.String(), nil
+	case *RCmd:
+		g.imports["bytes"] = true
+		g.imports["os"] = true
+		return fmt.Sprintf("if contents, err := os.ReadFile(%s); err == nil && len(contents) > 0 {\nst.appendQueue = append(st.appendQueue, bytes.TrimSuffix(contents, []byte{'\\n'}))\n}\n", strconv.Quote(c.fileName())), nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrCannotCompile, c.source())
+}
+
+// compileCommands writes the body of the cycle loop, one command after the other.
+func (g *goCompiler) compileCommands() error {
+	g.findLiveCommands()
+	for i, in := range g.s.program {
+		if label, ok := g.labels[i]; ok {
+			fmt.Fprintf(&g.body, "%s:\n", label)
+		}
+		if !g.live[i] {
+			continue
+		}
+		c, addr := in.cmd, in.addr
+		if named := namedAddress(addr); named != nil {
+			// Named addresses are Go code of gosed, which the generated code can't call
+			return g.s.scriptError(c, fmt.Errorf("%w: the named address %s", ErrCannotCompile, named.name))
+		}
+		if block, ok := c.(*BlockCmd); ok {
+			if addr != nil {
+				fmt.Fprintf(&g.body, "// %s\nif !(%s) {\ngoto %s\n}\n", c.source(), g.condition(addr), g.labels[block.end])
+			}
+			continue
+		}
+		code, err := g.code(c)
+		if err != nil {
+			return g.s.scriptError(c, err)
+		}
+		if code == "" {
+			continue
+		}
+		fmt.Fprintf(&g.body, "// %s\n", strings.ReplaceAll(c.source(), "\n", "\n// "))
+		if addr != nil {
+			code = "if " + g.condition(addr) + " {\n" + code + "}\n"
+		}
+		g.body.WriteString(code)
+	}
+	return nil
+}
+
+// namedAddress returns the @name address addr is or starts or ends with, nil when there is none.
+func namedAddress(addr *address) *address {
+	switch {
+	case addr == nil:
+		return nil
+	case addr.addressType == addressNamed:
+		return addr
+	case addr.addressType == addressSpan:
+		if named := namedAddress(addr.first); named != nil {
+			return named
+		}
+		return namedAddress(addr.last)
+	}
+	return nil
+}
+
+// compileScript turns the parsed script of s into the source of a Go file of package pkg, with a function
+// funcName(r io.Reader, w io.Writer) error running it. scriptName is only used in comments.
+func compileScript(s *Sed, pkg, funcName, scriptName string, quiet bool) ([]byte, error) {
+	g := newGoCompiler(s, funcName, quiet)
+	if err := g.compileCommands(); err != nil {
+		return nil, err
+	}
+	if g.exitCode {
+		g.imports["fmt"] = true
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by gosed compile from %s. DO NOT EDIT.\n\npackage %s\n\nimport (\n", scriptName, pkg)
+	imports := make([]string, 0, len(g.imports))
+	for name := range g.imports {
+		imports = append(imports, name)
+	}
+	sort.Strings(imports)
+	for _, name := range imports {
+		fmt.Fprintf(&out, "\t%q\n", name)
+	}
+	out.WriteString(")\n")
+	if g.vars.Len() > 0 {
+		fmt.Fprintf(&out, "\nvar (\n%s)\n", g.vars.String())
+	}
+	out.WriteString(strings.ReplaceAll(goRuntime, "PREFIX", g.prefix))
+	if g.replaceNth {
+		out.WriteString(strings.ReplaceAll(goReplaceNth, "PREFIX", g.prefix))
+	}
+	if g.spans > 0 {
+		out.WriteString(strings.ReplaceAll(goInSpan, "PREFIX", g.prefix))
+	}
+
+	fmt.Fprintf(&out, "\n// %s runs the sed script %s over r and writes its output to w.\n", funcName, scriptName)
+	fmt.Fprintf(&out, "func %s(r io.Reader, w io.Writer) error {\n", funcName)
+	fmt.Fprintf(&out, "st := &%sState{in: bufio.NewReader(r), out: bufio.NewWriter(w)}\n", g.prefix)
+	if g.spans > 0 {
+		fmt.Fprintf(&out, "var spans [%d]bool\n", g.spans)
+	}
+	out.WriteString("for !st.quit {\nif st.restart {\nst.restart = false\n} else {\nline, err := st.readLine()\nif err == io.EOF {\nbreak\n}\nif err != nil {\nreturn err\n}\nst.ps = line\nst.lineNumber++\n}\nst.substituted = false\n")
+	out.WriteString(g.body.String())
+	if g.endOfScript {
+		out.WriteString("endOfScript:\n")
+	}
+	if !g.quiet && (g.fallThrough || g.endOfScript) {
+		out.WriteString("st.printPatternSpace()\n")
+	}
+	if g.endOfCycle {
+		out.WriteString("endOfCycle:\n")
+	}
+	out.WriteString("st.flushAppendQueue()\n}\nif err := st.out.Flush(); err != nil {\nreturn err\n}\n")
+	if g.exitCode {
+		out.WriteString("if st.exitCode != 0 {\nreturn fmt.Errorf(\"exit status %d\", st.exitCode)\n}\n")
+	}
+	out.WriteString("return nil\n}\n")
+	return format.Source(out.Bytes())
+}
+
+// compileMain is `gosed compile [-o FILE] [--package NAME] [--func NAME] [-n] SCRIPT_FILE | -e SCRIPT`. It writes
+// the Go source to FILE, or to the standard output.
+func compileMain(args []string) int {
+	flags := subcommandFlags("compile", "[-o FILE] [--package NAME] [--func NAME] [-n] SCRIPT_FILE | -e SCRIPT", "Turn a sed script into a standalone Go function Transform(r io.Reader, w io.Writer) error, with its\nregexes precompiled and gotos for its blocks and branches.")
+	output := flags.String("o", "", "Write the Go source to `FILE` instead of the standard output")
+	pkg := flags.String("package", "main", "The `NAME` of the package of the generated file")
+	funcName := flags.String("func", "Transform", "The `NAME` of the generated function")
+	expression := flags.String("e", "", "The `SCRIPT` to compile, instead of reading it from a file")
+	silent := flags.Bool("n", false, "Don't print the pattern space at the end of each cycle, like sed -n")
+	operands, status, done := parseSubcommandArgs(flags, map[string]string{"output": "o", "expression": "e", "quiet": "n", "silent": "n"}, args)
+	if done {
+		return status
+	}
+	var err error
+	if len(operands) > 1 || len(operands) == 0 && *expression == "" || len(operands) == 1 && *expression != "" {
+		err = errors.New("compile takes either one script file or -e SCRIPT")
+	}
+	if err == nil && (!token.IsIdentifier(*funcName) || !token.IsIdentifier(*pkg)) {
+		err = errors.New("--package and --func must be Go identifiers")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error, %s\n", err.Error())
+		return 2
+	}
+
+	script, scriptName := []byte(*expression), "-e"
+	if len(operands) == 1 {
+		scriptName = filepath.Base(operands[0])
+		if script, err = os.ReadFile(operands[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading script file %s: %s\n", operands[0], err.Error())
+			return 2
+		}
+	}
+	s := new(Sed)
+	s.Init()
+	s.posix = *posix
+	if err := s.parseScript(bytes.TrimSuffix(script, newLine)); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	src, err := compileScript(s, *pkg, *funcName, scriptName, s.quiet || *silent)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	if *output == "" {
+		os.Stdout.Write(src)
+		return 0
+	}
+	if err := os.WriteFile(*output, src, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing %s: %s\n", *output, err.Error())
+		return 2
+	}
+	return 0
+}
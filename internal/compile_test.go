@@ -0,0 +1,120 @@
+// compile_test.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+
+package sed
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCompile compiles scripts to Go, builds them into a program and checks that it prints what sed does.
+func TestCompile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Building the compiled scripts takes a while")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("No go command to build the compiled scripts with")
+	}
+	scripts := []string{
+		"s/o/0/g\nx\np\nx\n2,3D\nG",
+		":a\ns/l/x/\nta",
+		"N\nP\nD",
+		"/e/{\n    s/e/E/\n    p\n}",
+		"y/abc/xyz/",
+		"1i\\\nhead\n2a\\\ntail\n3c\\\nchanged",
+		"s/l/L/2",
+		"=\n4q",
+		"#n\n2,3p",
+		"b end\ns/a/X/\n:end\ns/e/Y/",
+		"1!G\nh\nd",
+		"4,5c\\\nlast two",
+		"2n\ns/^/> /",
+		"/l/,/m/d",
+		"/e/,3s/^/> /",
+		"2,/a/!d",
+		"/t/,$p",
+		"/b/,/l/{\n    =\n}\n/x/,/y/p",
+	}
+	const input = "alpha\nbeta\ngamma\ndelta\nepsilon"
+
+	dir := t.TempDir()
+	var dispatch strings.Builder
+	for i, script := range scripts {
+		s := new(Sed)
+		s.Init()
+		if err := s.parseScript([]byte(script)); err != nil {
+			t.Fatalf("%q: %v", script, err)
+		}
+		src, err := compileScript(s, "main", fmt.Sprintf("Script%d", i), "test", s.quiet)
+		if err != nil {
+			t.Fatalf("%q: %v", script, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("script%d.go", i)), src, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&dispatch, "\t%q: Script%d,\n", fmt.Sprint(i), i)
+	}
+	files := map[string]string{
+		"go.mod": "module compiled\n\ngo 1.20\n",
+		"main.go": "package main\n\nimport (\n\t\"io\"\n\t\"os\"\n)\n\nfunc main() {\n" +
+			"\tscripts := map[string]func(io.Reader, io.Writer) error{\n" + dispatch.String() + "\t}\n" +
+			"\tif err := scripts[os.Args[1]](os.Stdin, os.Stdout); err != nil {\n\t\tos.Exit(3)\n\t}\n}\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	build := exec.Command(goTool, "build", "-o", "compiled")
+	build.Dir = dir
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("Building the compiled scripts failed: %v\n%s", err, out)
+	}
+	vet := exec.Command(goTool, "vet", ".")
+	vet.Dir = dir
+	if out, err := vet.CombinedOutput(); err != nil {
+		t.Errorf("go vet complains about the compiled scripts: %v\n%s", err, out)
+	}
+
+	for i, script := range scripts {
+		run := exec.Command(filepath.Join(dir, "compiled"), fmt.Sprint(i))
+		run.Stdin = strings.NewReader(input)
+		out, err := run.Output()
+		if err != nil {
+			t.Errorf("%q: running the compiled script failed: %v", script, err)
+			continue
+		}
+		checkString(t, fmt.Sprintf("compiled %q", script), runSed(t, script, input), string(out))
+	}
+}
+
+func TestCompileNamedAddress(t *testing.T) {
+	if err := RegisterAddress("even", func(string) (Address, error) {
+		return AddressFunc(func(ctx *ExecContext) bool { return ctx.LineNumber()%2 == 0 }), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer delete(addressDefs, "even")
+	for _, script := range []string{"@even p", "/a/,@even d"} {
+		s := new(Sed)
+		s.Init()
+		if err := s.parseScript([]byte(script)); err != nil {
+			t.Fatalf("%q: %v", script, err)
+		}
+		_, err := compileScript(s, "main", "Transform", "test", false)
+		if !errors.Is(err, ErrCannotCompile) || !strings.Contains(err.Error(), "@even") {
+			t.Errorf("%q: expected %v naming @even, got %v", script, ErrCannotCompile, err)
+		}
+	}
+}
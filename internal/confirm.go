@@ -0,0 +1,86 @@
+// confirm.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+
+// Package sed implements the entire program, from this specific part, we implement --confirm, which asks before each replacement of s
+package sed
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// The escape sequences the match is shown between, reverse video on any terminal
+const (
+	highlightStart = "\x1b[7m"
+	highlightEnd   = "\x1b[27m"
+)
+
+// confirmer asks on the terminal, for every match an s command is about to replace, whether to replace it. Like
+// the stepper, it reads the answers from the terminal so that the input of the script can still come from stdin.
+type confirmer struct {
+	in   *bufio.Scanner
+	out  io.Writer
+	all  bool // a: replace this match and every one after it without asking
+	none bool // q: leave this match and every one after it alone without asking
+}
+
+// newConfirmer creates a confirmer reading answers from in and asking on out.
+func newConfirmer(in io.Reader, out io.Writer) *confirmer {
+	return &confirmer{in: bufio.NewScanner(in), out: out}
+}
+
+// openConfirmer creates the confirmer for --confirm on the terminal.
+func openConfirmer() (*confirmer, error) {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return nil, fmt.Errorf("--confirm needs a terminal: %w", err)
+	}
+	return newConfirmer(tty, os.Stderr), nil
+}
+
+// confirmed reports whether the match line[start:end] of c is to be replaced, asking when --confirm is given. done
+// is what c has already built of the new pattern space before line, and is shown with it.
+func (s *Sed) confirmed(c *SCmd, done, line []byte, start, end int) bool {
+	if s.confirmer == nil {
+		return true
+	}
+	return s.confirmer.ask(c, s.lineNumber, done, line, start, end)
+}
+
+// ask shows the line with the match highlighted and asks what to do with it until it gets an answer: y replaces
+// it, n leaves it, a replaces it and every match after it, q leaves it and every match after it. A terminal that
+// closes is a q.
+func (c *confirmer) ask(cmd *SCmd, lineNumber int, done, line []byte, start, end int) bool {
+	if c.all || c.none {
+		return c.all
+	}
+	fmt.Fprintf(c.out, "%d: %s%s%s%s%s%s\n", lineNumber, done, line[:start], highlightStart, line[start:end], highlightEnd, line[end:])
+	for {
+		fmt.Fprintf(c.out, "%s? [y,n,a,q] ", cmd.source())
+		if !c.in.Scan() {
+			fmt.Fprintln(c.out)
+			c.none = true
+			return false
+		}
+		switch strings.ToLower(strings.TrimSpace(c.in.Text())) {
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		case "a", "all":
+			c.all = true
+			return true
+		case "q", "quit":
+			c.none = true
+			return false
+		}
+		fmt.Fprintln(c.out, "y: replace this match, n: leave it, a: replace it and every match after it, q: leave it and every match after it")
+	}
+}
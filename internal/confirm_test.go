@@ -0,0 +1,43 @@
+// confirm_test.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+package sed
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestConfirm(t *testing.T) {
+	for _, test := range []struct {
+		script, input, answers, expected string
+	}{
+		{"s/a/X/g", "a a a\n", "y\nn\ny\n", "X a X\n"},
+		{"s/a/X/g", "a a\na a\n", "n\na\n", "a X\nX X\n"},
+		{"s/a/X/g", "a a\na a\n", "y\nq\n", "X a\na a\n"},
+		{"s/a/X/g;t\ns/^/!/", "a\n", "n\ny\n", "!a\n"},
+		{"s/a/X/2", "aaa\nab\n", "y\nn\n", "aXa\nab\n"},
+		{"s/(b+)/<${1}>/g", "ab bb\n", "what\ny\ny\n", "a<b> <bb>\n"},
+		{"s/a/X/g", "a a\n", "y\n", "X a\n"},
+	} {
+		var prompts bytes.Buffer
+		output := runSedWith(t, test.script, test.input, func(s *Sed) {
+			s.confirmer = newConfirmer(strings.NewReader(test.answers), &prompts)
+		})
+		checkString(t, test.script+" answering "+strings.ReplaceAll(test.answers, "\n", " "), test.expected, output)
+	}
+
+	var prompts bytes.Buffer
+	runSedWith(t, "s/b/X/g", "abcb\n", func(s *Sed) {
+		s.confirmer = newConfirmer(strings.NewReader("y\ny\n"), &prompts)
+	})
+	for _, expected := range []string{"1: a" + highlightStart + "b" + highlightEnd + "cb\n", "1: aXc" + highlightStart + "b" + highlightEnd + "\n", "s/b/X/g? [y,n,a,q] "} {
+		if !strings.Contains(prompts.String(), expected) {
+			t.Errorf("Expected %q in the prompts:\n%q", expected, prompts.String())
+		}
+	}
+}
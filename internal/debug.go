@@ -0,0 +1,90 @@
+// debug.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+
+// Package sed implements the entire program, from this specific part, we implement --debug, which annotates a run like GNU sed's --debug does
+package sed
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// debugEscape makes the pattern and hold space readable on a single line, the way the l command would show them.
+func debugEscape(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		switch {
+		case c == '\\':
+			sb.WriteString("\\\\")
+		case c == '\n':
+			sb.WriteString("\\n")
+		case c == '\t':
+			sb.WriteString("\\t")
+		case c == '\r':
+			sb.WriteString("\\r")
+		case c < ' ' || c == 0x7f:
+			fmt.Fprintf(&sb, "\\%03o", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// debugObserver writes the trace of --debug to w, as one of the observers of the Sed.
+type debugObserver struct {
+	NopObserver
+	observerHooks
+	w io.Writer
+}
+
+// program prints the parsed program of s in canonical sed syntax, laid out like `gosed fmt` does without the comments.
+func (d *debugObserver) program(s *Sed) {
+	fmt.Fprintln(d.w, "SED PROGRAM:")
+	var items []scriptItem
+	for _, in := range s.program {
+		items = append(items, scriptItem{cmd: in.cmd})
+	}
+	formatItems(d.w, items, "  ")
+}
+
+// CycleStart prints where the line starting a new cycle came from, and the pattern space it gave.
+func (d *debugObserver) CycleStart(ctx *ExecContext) {
+	name := inputFilename
+	if name == "" {
+		name = "STDIN"
+	}
+	fmt.Fprintf(d.w, "INPUT:   '%s' line %d\n", name, ctx.s.lineNumber)
+	fmt.Fprintf(d.w, "PATTERN: %s\n", debugEscape(ctx.s.patternSpace))
+}
+
+// Command prints a command that is about to be executed.
+func (d *debugObserver) Command(_ *ExecContext, cmd CommandInfo) {
+	fmt.Fprintf(d.w, "COMMAND: %s\n", strings.ReplaceAll(cmd.Source, "\n", "\n         "))
+}
+
+// ran prints the pattern and hold space an executed command left behind, and the named hold registers.
+func (d *debugObserver) ran(ctx *ExecContext, _ int, _ Cmd, _ bool) {
+	s := ctx.s
+	fmt.Fprintf(d.w, "PATTERN: %s\n", debugEscape(s.patternSpace))
+	fmt.Fprintf(d.w, "HOLD:    %s\n", debugEscape(s.holdSpace))
+	names := make([]string, 0, len(s.registers))
+	for name := range s.registers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(d.w, "HOLD:%s: %s\n", name, debugEscape(s.registers[name]))
+	}
+}
+
+// scriptEnd marks the end of a cycle, right before the pattern space gets printed.
+func (d *debugObserver) scriptEnd(*ExecContext) {
+	fmt.Fprintln(d.w, "END-OF-CYCLE:")
+}
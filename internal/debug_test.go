@@ -0,0 +1,79 @@
+// debug_test.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+package sed
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDebugTrace(t *testing.T) {
+	s := new(Sed)
+	s.Init()
+	if err := s.parseScript([]byte("1h;h:acc\n/b/b end\ns/a/A/\n:end\nG:acc")); err != nil {
+		t.Fatal(err)
+	}
+	// The trace goes where the output does, in between its lines like on a terminal
+	var out bytes.Buffer
+	trace := &debugObserver{w: &out}
+	trace.program(s)
+	s.observe(trace)
+	s.unbuffered = true
+	if err := s.Run(strings.NewReader("a\nb\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	checkString(t, "debug trace", `SED PROGRAM:
+  1h
+  h:acc
+  /b/b end
+  s/a/A/
+  :end
+  G:acc
+INPUT:   'STDIN' line 1
+PATTERN: a
+COMMAND: 1h
+PATTERN: a
+HOLD:    a
+COMMAND: h:acc
+PATTERN: a
+HOLD:    a
+HOLD:acc: a
+COMMAND: s/a/A/
+PATTERN: A
+HOLD:    a
+HOLD:acc: a
+COMMAND: :end
+PATTERN: A
+HOLD:    a
+HOLD:acc: a
+COMMAND: G:acc
+PATTERN: A\na
+HOLD:    a
+HOLD:acc: a
+END-OF-CYCLE:
+A
+a
+INPUT:   'STDIN' line 2
+PATTERN: b
+COMMAND: h:acc
+PATTERN: b
+HOLD:    a
+HOLD:acc: b
+COMMAND: /b/b end
+PATTERN: b
+HOLD:    a
+HOLD:acc: b
+COMMAND: G:acc
+PATTERN: b\nb
+HOLD:    a
+HOLD:acc: b
+END-OF-CYCLE:
+b
+b
+`, out.String())
+}
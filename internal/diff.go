@@ -0,0 +1,280 @@
+// diff.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+
+// Package sed implements the entire program, from this specific part, we implement the unified diffs --diff prints instead of editing files in place
+package sed
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// diffContext is the number of unchanged lines around the changes of a hunk, as diff -u has it.
+const diffContext = 3
+
+// diffOp is a line of a diff: one kept from the old text and the new ('='), deleted from the old ('-') or
+// inserted in the new ('+').
+type diffOp struct {
+	kind       byte
+	line       []byte // with its line ending, if it has one
+	oldN, newN int    // the number of the line in the old and new text, from 0
+}
+
+// diffEdit prints what editToTemp wrote to temp as a unified diff against the file name, instead of putting it in
+// place like commitEdit, and reports whether the two differ. The temporary file is removed either way.
+func diffEdit(w *bufio.Writer, name, temp string) (bool, error) {
+	defer os.Remove(temp)
+	old, err := os.ReadFile(name)
+	if err != nil {
+		return false, fmt.Errorf("Error reading input file: %w", err)
+	}
+	edited, err := os.ReadFile(temp)
+	if err != nil {
+		return false, fmt.Errorf("Error reading temp file: %w", err)
+	}
+	changed, err := unifiedDiff(w, filepath.ToSlash(name), old, edited)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		return changed, fmt.Errorf("Error writing output: %w", err)
+	}
+	return changed, nil
+}
+
+// unifiedDiff writes the differences between old and edited as a unified diff with name in the file headers, and
+// reports whether there were any. Nothing is written when there aren't.
+func unifiedDiff(w io.Writer, name string, old, edited []byte) (bool, error) {
+	if bytes.Equal(old, edited) {
+		return false, nil
+	}
+	ops := diffLines(splitLines(old), splitLines(edited))
+	if _, err := fmt.Fprintf(w, "--- %s\n+++ %s\n", name, name); err != nil {
+		return true, err
+	}
+	for start := 0; start < len(ops); {
+		// A hunk takes the changes with no more than twice the context between them
+		for start < len(ops) && ops[start].kind == '=' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		end := start
+		for i := start; i < len(ops) && i-end <= 2*diffContext; i++ {
+			if ops[i].kind != '=' {
+				end = i + 1
+			}
+		}
+		from, to := max(start-diffContext, 0), min(end+diffContext, len(ops))
+		if err := writeHunk(w, ops[from:to]); err != nil {
+			return true, err
+		}
+		start = end
+	}
+	return true, nil
+}
+
+// writeHunk writes the lines of a hunk after its "@@ -l,s +l,s @@" header.
+func writeHunk(w io.Writer, ops []diffOp) error {
+	oldStart, newStart, oldCount, newCount := ops[0].oldN, ops[0].newN, 0, 0
+	for _, op := range ops {
+		if op.kind != '+' {
+			oldCount++
+		}
+		if op.kind != '-' {
+			newCount++
+		}
+	}
+	if _, err := fmt.Fprintf(w, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount)); err != nil {
+		return err
+	}
+	for _, op := range ops {
+		prefix := op.kind
+		if prefix == '=' {
+			prefix = ' '
+		}
+		if _, err := w.Write(append([]byte{prefix}, op.line...)); err != nil {
+			return err
+		}
+		if !bytes.HasSuffix(op.line, newLine) {
+			if _, err := io.WriteString(w, "\n\\ No newline at end of file\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// hunkRange formats the start and length of the lines of a hunk in one of the texts. Lines are counted from 1, and
+// an empty range starts at the line before it.
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// splitLines cuts text after each newline, the last line possibly lacking one.
+func splitLines(text []byte) [][]byte {
+	lines := bytes.SplitAfter(text, newLine)
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns the shortest edit script turning the lines a into the lines b.
+func diffLines(a, b [][]byte) []diffOp {
+	// Lines are compared by number, the same number for the same text
+	ids := make(map[string]int)
+	number := func(lines [][]byte) []int {
+		numbered := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := ids[string(line)]
+			if !ok {
+				id = len(ids)
+				ids[string(line)] = id
+			}
+			numbered[i] = id
+		}
+		return numbered
+	}
+	d := &differ{a: number(a), b: number(b)}
+	d.keptA, d.keptB = make([]bool, len(a)), make([]bool, len(b))
+	d.compare(0, len(a), 0, len(b))
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && !d.keptA[i]:
+			ops = append(ops, diffOp{'-', a[i], i, j})
+			i++
+		case j < len(b) && !d.keptB[j]:
+			ops = append(ops, diffOp{'+', b[j], i, j})
+			j++
+		default:
+			ops = append(ops, diffOp{'=', a[i], i, j})
+			i++
+			j++
+		}
+	}
+	return ops
+}
+
+// differ finds the lines a and b have in common with Myers' O(ND) algorithm, in linear space: each range is split
+// where the paths from both of its ends meet, and the halves are compared on their own.
+type differ struct {
+	a, b         []int
+	keptA, keptB []bool
+}
+
+// compare marks the lines a[aLo:aHi] and b[bLo:bHi] have in common.
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		d.keptA[aLo], d.keptB[bLo] = true, true
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+		d.keptA[aHi], d.keptB[bHi] = true, true
+	}
+	if aLo == aHi || bLo == bHi {
+		return
+	}
+	if x, y, ok := d.split(aLo, aHi, bLo, bHi); ok {
+		d.compare(aLo, x, bLo, y)
+		d.compare(x, aHi, y, bHi)
+	}
+}
+
+// split returns a point a shortest edit script of a[aLo:aHi] into b[bLo:bHi] goes through, other than its ends. There
+// is none when the ranges have nothing in common.
+func (d *differ) split(aLo, aHi, bLo, bHi int) (int, int, bool) {
+	a, b := d.a[aLo:aHi], d.b[bLo:bHi]
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	offset := maxD
+	v1, v2 := make([]int, 2*maxD+2), make([]int, 2*maxD+2)
+	for i := range v1 {
+		v1[i], v2[i] = -1, -1
+	}
+	v1[offset+1], v2[offset+1] = 0, 0
+	delta := n - m
+	// With an odd delta the forward path is the one to find the overlap, with an even one the reverse path
+	front := delta%2 != 0
+	k1start, k1end, k2start, k2end := 0, 0, 0, 0
+	for step := 0; step < maxD; step++ {
+		for k1 := -step + k1start; k1 <= step-k1end; k1 += 2 {
+			k1Offset := offset + k1
+			var x1 int
+			if k1 == -step || (k1 != step && v1[k1Offset-1] < v1[k1Offset+1]) {
+				x1 = v1[k1Offset+1]
+			} else {
+				x1 = v1[k1Offset-1] + 1
+			}
+			y1 := x1 - k1
+			for x1 < n && y1 < m && a[x1] == b[y1] {
+				x1++
+				y1++
+			}
+			v1[k1Offset] = x1
+			switch {
+			case x1 > n:
+				k1end += 2
+			case y1 > m:
+				k1start += 2
+			case front:
+				k2Offset := offset + delta - k1
+				if k2Offset >= 0 && k2Offset < len(v2) && v2[k2Offset] != -1 && x1 >= n-v2[k2Offset] {
+					return aLo + x1, bLo + y1, true
+				}
+			}
+		}
+		for k2 := -step + k2start; k2 <= step-k2end; k2 += 2 {
+			k2Offset := offset + k2
+			var x2 int
+			if k2 == -step || (k2 != step && v2[k2Offset-1] < v2[k2Offset+1]) {
+				x2 = v2[k2Offset+1]
+			} else {
+				x2 = v2[k2Offset-1] + 1
+			}
+			y2 := x2 - k2
+			for x2 < n && y2 < m && a[n-x2-1] == b[m-y2-1] {
+				x2++
+				y2++
+			}
+			v2[k2Offset] = x2
+			switch {
+			case x2 > n:
+				k2end += 2
+			case y2 > m:
+				k2start += 2
+			case !front:
+				k1Offset := offset + delta - k2
+				if k1Offset >= 0 && k1Offset < len(v1) && v1[k1Offset] != -1 {
+					x1 := v1[k1Offset]
+					y1 := offset + x1 - k1Offset
+					if x1 >= n-x2 {
+						return aLo + x1, bLo + y1, true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
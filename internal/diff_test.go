@@ -0,0 +1,111 @@
+// diff_test.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+package sed
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// longestCommon is the number of lines a and b have in common, found the quadratic way.
+func longestCommon(a, b [][]byte) int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if bytes.Equal(a[i], b[j]) {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+	return lengths[0][0]
+}
+
+func TestDiffLines(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	lines := func() [][]byte {
+		var text []byte
+		for i := random.Intn(20); i > 0; i-- {
+			text = append(text, "abcd"[random.Intn(4)], '\n')
+		}
+		return splitLines(text)
+	}
+	for n := 0; n < 500; n++ {
+		a, b := lines(), lines()
+		var gotA, gotB [][]byte
+		kept := 0
+		for _, op := range diffLines(a, b) {
+			if op.kind != '+' {
+				gotA = append(gotA, op.line)
+			}
+			if op.kind != '-' {
+				gotB = append(gotB, op.line)
+			}
+			if op.kind == '=' {
+				kept++
+			}
+		}
+		checkString(t, "old lines", string(bytes.Join(a, nil)), string(bytes.Join(gotA, nil)))
+		checkString(t, "new lines", string(bytes.Join(b, nil)), string(bytes.Join(gotB, nil)))
+		if expected := longestCommon(a, b); kept != expected {
+			t.Errorf("%q -> %q: kept %d lines instead of %d", a, b, kept, expected)
+		}
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	var old, edited []string
+	for i := 1; i <= 20; i++ {
+		old = append(old, "line "+strings.Repeat("x", i%3))
+		edited = append(edited, "line "+strings.Repeat("x", i%3))
+	}
+	edited[1] = "changed"
+	edited = append(edited[:10], edited[11:]...)
+	edited = append(edited, "end")
+
+	var out bytes.Buffer
+	changed, err := unifiedDiff(&out, "dir/file", []byte(strings.Join(old, "\n")+"\n"), []byte(strings.Join(edited, "\n")))
+	if err != nil || !changed {
+		t.Fatalf("Expected a change, got %v, %v", changed, err)
+	}
+	// The lines "line " are compared without their trailing space, which editors strip
+	checkString(t, "unified diff", `--- dir/file
++++ dir/file
+@@ -1,5 +1,5 @@
+ line x
+-line xx
++changed
+ line
+ line x
+ line xx
+@@ -8,7 +8,6 @@
+ line xx
+ line
+ line x
+-line xx
+ line
+ line x
+ line xx
+@@ -18,3 +17,4 @@
+ line
+ line x
+ line xx
++end
+\ No newline at end of file
+`, strings.ReplaceAll(out.String(), "line \n", "line\n"))
+
+	out.Reset()
+	if changed, _ := unifiedDiff(&out, "same", []byte("a\n"), []byte("a\n")); changed || out.Len() > 0 {
+		t.Errorf("Expected no diff for equal texts, got %q", out.String())
+	}
+}
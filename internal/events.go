@@ -0,0 +1,145 @@
+// events.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+
+// Package sed implements the entire program, from this specific part, we implement --json, which reports what the script does as a stream of JSON events for editors and other tools
+package sed
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"unicode/utf8"
+)
+
+// eventWriter writes the events of --json, one JSON object per line:
+//
+//	{"type":"begin","file":"a.go"}
+//	{"type":"substitution","file":"a.go","line":3,"command":"s/foo/bar/g","start":4,"end":7,"old":"foo","new":"bar"}
+//	{"type":"print","file":"a.go","line":3,"command":"p","text":"bar"}
+//	{"type":"end","file":"a.go","lines":10,"substitutions":1}
+//
+// Lines are numbered from the start of each file. The offsets of a substitution are those of the old text in the pattern space before the s or m command ran, which is
+// the input line unless N or another command changed it. Text that isn't valid UTF-8 is written as
+// {"bytes":"BASE64"} instead of a string. It is told what happens as one of the observers of the Sed.
+type eventWriter struct {
+	NopObserver
+	observerHooks
+	w            io.Writer
+	enc          *json.Encoder
+	file         string
+	lineBase     int // the line number of the Sed before the file began, line numbers don't start over without -i
+	linesRead    int // the counters of the Sed when the file began
+	replacements int
+	command      string // the command that runs, in canonical sed syntax
+}
+
+// eventText is text that is written as a JSON string when it is valid UTF-8, and as base64 bytes otherwise.
+type eventText []byte
+
+func (t eventText) MarshalJSON() ([]byte, error) {
+	var v any = string(t)
+	if !utf8.Valid(t) {
+		v = struct {
+			Bytes string `json:"bytes"`
+		}{base64.StdEncoding.EncodeToString(t)}
+	}
+	// Like the events themselves, without escaping <, > and &, which are everywhere in code
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), newLine), nil
+}
+
+type fileEvent struct {
+	Type          string `json:"type"`
+	File          string `json:"file"`
+	Lines         *int   `json:"lines,omitempty"`
+	Substitutions *int   `json:"substitutions,omitempty"`
+}
+
+type substitutionEvent struct {
+	Type    string    `json:"type"`
+	File    string    `json:"file"`
+	Line    int       `json:"line"`
+	Command string    `json:"command"`
+	Start   int       `json:"start"`
+	End     int       `json:"end"`
+	Old     eventText `json:"old"`
+	New     eventText `json:"new"`
+}
+
+type printEvent struct {
+	Type    string    `json:"type"`
+	File    string    `json:"file"`
+	Line    int       `json:"line"`
+	Command string    `json:"command"`
+	Text    eventText `json:"text"`
+}
+
+// newEventWriter creates an eventWriter writing to w.
+func newEventWriter(w io.Writer) *eventWriter {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &eventWriter{w: w, enc: enc}
+}
+
+// beginFile writes the begin event of the input file name.
+func (e *eventWriter) beginFile(ctx *ExecContext, name string) {
+	s := ctx.s
+	e.file, e.lineBase, e.linesRead, e.replacements = name, s.lineNumber, s.linesRead, s.replacements
+	if editInplace.enabled {
+		e.lineBase = 0
+	}
+	e.enc.Encode(fileEvent{Type: "begin", File: name})
+}
+
+// endFile writes the end event of the current file, with the lines read from it and the substitutions made in it,
+// and flushes the events so far when w is buffered.
+func (e *eventWriter) endFile(ctx *ExecContext) error {
+	s := ctx.s
+	lines, substitutions := s.linesRead-e.linesRead, s.replacements-e.replacements
+	if err := e.enc.Encode(fileEvent{Type: "end", File: e.file, Lines: &lines, Substitutions: &substitutions}); err != nil {
+		return err
+	}
+	return e.flush()
+}
+
+// flush writes out the events buffered in w, if it is buffered.
+func (e *eventWriter) flush() error {
+	if f, ok := e.w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Command remembers the command about to run, for its events.
+func (e *eventWriter) Command(_ *ExecContext, cmd CommandInfo) {
+	e.command = cmd.Source
+}
+
+// Substitution writes the event of the s or m command replacing old, at start in the pattern space, with replacement.
+func (e *eventWriter) Substitution(ctx *ExecContext, start int, old, replacement []byte) {
+	e.enc.Encode(substitutionEvent{
+		Type:    "substitution",
+		File:    e.file,
+		Line:    ctx.s.lineNumber - e.lineBase,
+		Command: e.command,
+		Start:   start,
+		End:     start + len(old),
+		Old:     old,
+		New:     replacement,
+	})
+}
+
+// printed writes the event of p or P printing text.
+func (e *eventWriter) printed(ctx *ExecContext, text []byte) {
+	e.enc.Encode(printEvent{Type: "print", File: e.file, Line: ctx.s.lineNumber - e.lineBase, Command: e.command, Text: text})
+}
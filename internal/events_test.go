@@ -0,0 +1,52 @@
+// events_test.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+package sed
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+	var events bytes.Buffer
+	var sed *Sed
+	runSedWith(t, "s/o+/<${0}>/g\n/x/s/a/A/2\n/b/s/b/B/\n/p/p", "foo zoo\nxaaa\nb\xff\np\n", func(s *Sed) {
+		s.setEvents(newEventWriter(&events))
+		s.beginFile("in.txt")
+		sed = s
+	})
+	if err := sed.endFile(); err != nil {
+		t.Fatal(err)
+	}
+	checkString(t, "events", `{"type":"begin","file":"in.txt"}
+{"type":"substitution","file":"in.txt","line":1,"command":"s/o+/<${0}>/g","start":1,"end":3,"old":"oo","new":"<oo>"}
+{"type":"substitution","file":"in.txt","line":1,"command":"s/o+/<${0}>/g","start":5,"end":7,"old":"oo","new":"<oo>"}
+{"type":"substitution","file":"in.txt","line":2,"command":"/x/s/a/A/2","start":2,"end":3,"old":"a","new":"A"}
+{"type":"substitution","file":"in.txt","line":3,"command":"/b/s/b/B/","start":0,"end":1,"old":"b","new":"B"}
+{"type":"print","file":"in.txt","line":4,"command":"/p/p","text":"p"}
+{"type":"end","file":"in.txt","lines":4,"substitutions":4}
+`, events.String())
+
+	events.Reset()
+	table := filepath.Join(t.TempDir(), "map.tsv")
+	if err := os.WriteFile(table, []byte("a\tA\nbc\tBC\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runSedWith(t, "m "+table, "abc a\n", func(s *Sed) { s.setEvents(newEventWriter(&events)) })
+	checkString(t, "m", fmt.Sprintf(`{"type":"substitution","file":"","line":1,"command":"m %[1]s","start":0,"end":1,"old":"a","new":"A"}
+{"type":"substitution","file":"","line":1,"command":"m %[1]s","start":1,"end":3,"old":"bc","new":"BC"}
+{"type":"substitution","file":"","line":1,"command":"m %[1]s","start":4,"end":5,"old":"a","new":"A"}
+`, table), events.String())
+
+	events.Reset()
+	runSedWith(t, "p", "\xff\xfe\n", func(s *Sed) { s.setEvents(newEventWriter(&events)) })
+	checkString(t, "bytes", `{"type":"print","file":"","line":1,"command":"p","text":{"bytes":"//4="}}
+`, events.String())
+}
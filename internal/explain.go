@@ -0,0 +1,458 @@
+// explain.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+
+// Package sed implements the entire program, from this specific part, we implement `gosed explain`, which describes a script in plain English
+package sed
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// explainItems writes, for each command of items, its canonical source followed by what it does in words: the lines
+// it runs on, the command itself, its regular expressions and its flags. The commands of a block are indented under it,
+// and only run on the lines the block does.
+func explainItems(w io.Writer, items []scriptItem) {
+	depth := 0
+	for _, item := range items {
+		if item.cmd == nil {
+			continue
+		}
+		if _, ok := item.cmd.(*BlockEndCmd); ok {
+			depth = max(depth-1, 0)
+			continue
+		}
+		indent := strings.Repeat(fmtIndent, depth)
+		fmt.Fprintf(w, "%s%s\n", indent, item.cmd.source())
+		for _, sentence := range explainCmd(item.cmd, depth > 0) {
+			fmt.Fprintf(w, "%s%s%s\n", indent, fmtIndent, sentence)
+		}
+		if _, ok := item.cmd.(*BlockCmd); ok {
+			depth++
+		}
+	}
+}
+
+// explainCmd returns the sentences describing cmd, the first one saying where it runs and what it does. nested is set
+// for the commands of a block.
+func explainCmd(cmd Cmd, nested bool) []string {
+	if c, ok := cmd.(*LabelCmd); ok {
+		return []string{fmt.Sprintf("The label %q, where b and t commands naming it go.", c.label)}
+	}
+	addr := cmd.getAddress()
+	sentences := []string{explainWhere(addr, nested) + explainAction(cmd) + "."}
+	for _, regex := range addressRegexes(addr) {
+		sentences = append(sentences, fmt.Sprintf("/%s/ matches %s.", escapeDelimiter(regex, '/'), explainRegex(regex)))
+		sentences = append(sentences, explainBRE(regex)...)
+	}
+	if c, ok := cmd.(*SCmd); ok {
+		sentences = append(sentences, fmt.Sprintf("The regular expression matches %s.", explainRegex(c.regex)))
+		sentences = append(sentences, explainBRE(c.regex)...)
+		if c.expands && c.nthOccurance == globalReplace {
+			sentences = append(sentences, "In the replacement, $N or ${N} stands for the text group N matched.")
+		}
+		if n := backReference(c.replace); n != "" {
+			sentence := fmt.Sprintf("\\%s is written as it is in gosed, not as the text group %s matched: write ${%s} instead", n, n, n)
+			if c.nthOccurance != globalReplace {
+				sentence += ", which gosed only expands with the g flag"
+			}
+			sentences = append(sentences, sentence+".")
+		}
+	}
+	return sentences
+}
+
+// breOperators are the operators of POSIX sed's BREs, and of GNU's, that gosed reads as plain characters since its
+// regular expressions are EREs, with what to write instead.
+var breOperators = []struct {
+	escaped, advice string
+}{
+	{"()", "\\( and \\) are plain parentheses in gosed: write ( and ) for a group."},
+	{"{}", "\\{ and \\} are plain braces in gosed: write {n}, {n,} or {n,m} to repeat."},
+	{"+", "\\+ is a plain + in gosed: write + for one or more times."},
+	{"?", "\\? is a plain ? in gosed: write ? for optionally."},
+	{"|", "\\| is a plain | in gosed: write | for either."},
+}
+
+// explainBRE returns a sentence for each BRE operator written in regex, saying that gosed reads it as a plain
+// character and what to write instead.
+func explainBRE(regex string) []string {
+	var sentences []string
+	for _, op := range breOperators {
+		for i := 0; i < len(regex); i++ {
+			if regex[i] == '[' {
+				i = skipBracket([]byte(regex), i) - 1
+			} else if regex[i] == '\\' && i+1 < len(regex) {
+				i++
+				if strings.IndexByte(op.escaped, regex[i]) >= 0 {
+					sentences = append(sentences, op.advice)
+					break
+				}
+			}
+		}
+	}
+	return sentences
+}
+
+// backReference returns the digit of the first \N of a replacement, "" when there is none.
+func backReference(replacement []byte) string {
+	for i := 0; i+1 < len(replacement); i++ {
+		if replacement[i] == '\\' {
+			if c := replacement[i+1]; c >= '1' && c <= '9' {
+				return string(c)
+			}
+			i++
+		}
+	}
+	return ""
+}
+
+// explainWhere returns the start of the sentence describing a command, saying which lines it runs on. Those of a
+// nested command are the lines its block runs on.
+func explainWhere(a *address, nested bool) string {
+	switch {
+	case a == nil && nested:
+		return "On those lines, "
+	case a == nil:
+		return "On every line, "
+	case nested && a.not:
+		return "Of those lines, on every one except " + explainLines(a) + ", "
+	case nested:
+		return "Of those lines, on " + explainLines(a) + ", "
+	case a.not:
+		return "On every line except " + explainLines(a) + ", "
+	}
+	return "On " + explainLines(a) + ", "
+}
+
+// explainLines returns the lines a selects, ignoring its '!'.
+func explainLines(a *address) string {
+	switch a.addressType {
+	case addressLine:
+		return "line " + strconv.Itoa(a.rangeStart)
+	case addressRange:
+		if a.rangeEnd <= a.rangeStart {
+			return "line " + strconv.Itoa(a.rangeStart)
+		}
+		return fmt.Sprintf("lines %d through %d", a.rangeStart, a.rangeEnd)
+	case addressToEndOfFile:
+		return fmt.Sprintf("line %d and every line after it", a.rangeStart)
+	case addressLastLine:
+		return "the last line"
+	case addressRegEx:
+		return "lines matching /" + escapeDelimiter(a.regex.String(), '/') + "/"
+	case addressNamed:
+		return "lines the " + a.name + " address selects"
+	case addressSpan:
+		return "each run of lines from " + explainSpanEnd(a.first, false) + " through " + explainSpanEnd(a.last, true)
+	}
+	return a.source()
+}
+
+// explainSpanEnd returns the line a, the first or last address of a span, stands for.
+func explainSpanEnd(a *address, last bool) string {
+	line := "a line"
+	if last {
+		line = "the next line"
+	}
+	switch a.addressType {
+	case addressRegEx:
+		return line + " matching /" + escapeDelimiter(a.regex.String(), '/') + "/"
+	case addressNamed:
+		return line + " the " + a.name + " address selects"
+	}
+	return explainLines(a)
+}
+
+// addressRegexes returns the regular expressions of a, in the order they are written.
+func addressRegexes(a *address) []string {
+	switch {
+	case a == nil:
+		return nil
+	case a.addressType == addressRegEx:
+		return []string{a.regex.String()}
+	case a.addressType == addressSpan:
+		return append(addressRegexes(a.first), addressRegexes(a.last)...)
+	}
+	return nil
+}
+
+// explainAction returns what cmd does, as the rest of the sentence explainWhere starts.
+func explainAction(cmd Cmd) string {
+	switch c := cmd.(type) {
+	case *ACmd:
+		return fmt.Sprintf("queue the text %q, to be written at the end of the cycle", c.text)
+	case *BCmd:
+		target := "the end of the script, ending the cycle"
+		if c.label != "" {
+			target = fmt.Sprintf("the label %q", c.label)
+		}
+		if c.conditional {
+			return "if a substitution was made since the last line was read or the last t branched, go to " + target
+		}
+		return "go to " + target
+	case *BlockCmd:
+		return "run the commands of the block, up to the matching }"
+	case *CCmd:
+		if c.addr.isRange() && !c.addr.not {
+			return fmt.Sprintf("delete the pattern space, and at the end of the range write the text %q", c.text)
+		}
+		return fmt.Sprintf("delete the pattern space and write the text %q instead", c.text)
+	case *DCmd:
+		if c.upToFirstNewLine {
+			return "delete the pattern space up to the first newline and start the next cycle, without reading a new line if anything is left"
+		}
+		return "delete the pattern space and start the next cycle"
+	case *EqlCmd:
+		return "write the line number"
+	case *GCmd:
+		if c.replace {
+			return "replace the pattern space with " + registerWords(c.register)
+		}
+		return "append a newline and " + registerWords(c.register) + " to the pattern space"
+	case *HCmd:
+		if c.replace {
+			return "replace " + registerWords(c.register) + " with the pattern space"
+		}
+		return "append a newline and the pattern space to " + registerWords(c.register)
+	case *ICmd:
+		return fmt.Sprintf("write the text %q right away", c.text)
+	case *MCmd:
+		return fmt.Sprintf("replace, in a single pass, the old text of each old<TAB>new line of the table %s with the new", c.fileName())
+	case *NCmd:
+		if c.append {
+			return "append a newline and the next line of input to the pattern space, or when there is none write the pattern space unless -n or --posix was given and quit"
+		}
+		return "write the pattern space unless -n was given and replace it with the next line of input, or quit when there is none"
+	case *PCmd:
+		if c.upToNewLine {
+			return "write the pattern space up to the first newline"
+		}
+		return "write the pattern space"
+	case *QCmd:
+		if c.exitCode != 0 {
+			return fmt.Sprintf("write the pattern space unless -n was given and quit with exit status %d", c.exitCode)
+		}
+		return "write the pattern space unless -n was given and quit"
+	case *RCmd:
+		return fmt.Sprintf("queue the contents of the file %s, to be written at the end of the cycle", strings.TrimSpace(string(c.text)))
+	case *SCmd:
+		which := "the first match"
+		switch {
+		case c.nthOccurance == globalReplace:
+			which = "every match"
+		case c.nthOccurance > 1:
+			which = "the " + ordinal(c.nthOccurance) + " match"
+		}
+		// The replacement is quoted as written, %q would double its backslashes
+		return fmt.Sprintf("replace %s of the regular expression with \"%s\"", which, c.replace)
+	case *XCmd:
+		return "exchange the pattern space and " + registerWords(c.register)
+	case *YCmd:
+		return fmt.Sprintf("replace each character of %q with the one at the same place in %q", string(c.from), string(c.to))
+	case *PluginCmd:
+		if c.text != "" {
+			return fmt.Sprintf("run the %s command registered from Go, with the argument %q", c.def.Name, c.text)
+		}
+		return fmt.Sprintf("run the %s command registered from Go", c.def.Name)
+	}
+	return "run " + cmd.source()
+}
+
+// registerWords names the hold space, or the named hold register when name isn't "".
+func registerWords(name string) string {
+	if name == "" {
+		return "the hold space"
+	}
+	return "the hold register " + name
+}
+
+// explainRegex returns what the regular expression r matches, in words. r is read the way the commands compile it,
+// as a POSIX extended regular expression.
+func explainRegex(r string) string {
+	re, err := syntax.Parse(r, syntax.POSIX)
+	if err != nil {
+		return "/" + r + "/"
+	}
+	return explainSyntax(re)
+}
+
+// explainSyntax describes the parsed regular expression re.
+func explainSyntax(re *syntax.Regexp) string {
+	switch re.Op {
+	case syntax.OpEmptyMatch, syntax.OpNoMatch:
+		return "nothing"
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return strconv.Quote(string(re.Rune)) + " in any case"
+		}
+		return strconv.Quote(string(re.Rune))
+	case syntax.OpCharClass:
+		return explainClass(re.Rune)
+	case syntax.OpAnyCharNotNL:
+		return "any character but a newline"
+	case syntax.OpAnyChar:
+		return "any character"
+	case syntax.OpBeginLine, syntax.OpBeginText:
+		return "the start"
+	case syntax.OpEndLine, syntax.OpEndText:
+		return "the end"
+	case syntax.OpWordBoundary:
+		return "a word boundary"
+	case syntax.OpNoWordBoundary:
+		return "anything but a word boundary"
+	case syntax.OpCapture:
+		group := "group " + strconv.Itoa(re.Cap)
+		if re.Name != "" {
+			group = "group " + re.Name
+		}
+		return group + " (" + explainSyntax(re.Sub[0]) + ")"
+	case syntax.OpStar:
+		return explainRepeated(re.Sub[0]) + " any number of times, or none"
+	case syntax.OpPlus:
+		return explainRepeated(re.Sub[0]) + " one or more times"
+	case syntax.OpQuest:
+		return "optionally " + explainRepeated(re.Sub[0])
+	case syntax.OpRepeat:
+		sub := explainRepeated(re.Sub[0])
+		switch {
+		case re.Max == -1:
+			return fmt.Sprintf("%s at least %d times", sub, re.Min)
+		case re.Min == re.Max:
+			return fmt.Sprintf("%s exactly %d times", sub, re.Min)
+		}
+		return fmt.Sprintf("%s between %d and %d times", sub, re.Min, re.Max)
+	case syntax.OpConcat:
+		parts := make([]string, len(re.Sub))
+		for i, sub := range re.Sub {
+			parts[i] = explainSyntax(sub)
+		}
+		return strings.Join(parts, ", then ")
+	case syntax.OpAlternate:
+		parts := make([]string, len(re.Sub))
+		for i, sub := range re.Sub {
+			parts[i] = explainSyntax(sub)
+		}
+		return "either " + strings.Join(parts, ", or ")
+	}
+	return "/" + re.String() + "/"
+}
+
+// explainRepeated describes re as what a repetition applies to, in parentheses when it is made of several parts.
+func explainRepeated(re *syntax.Regexp) string {
+	if re.Op == syntax.OpConcat || re.Op == syntax.OpAlternate || re.Op == syntax.OpCharClass && len(re.Rune) > 2 {
+		return "(" + explainSyntax(re) + ")"
+	}
+	return explainSyntax(re)
+}
+
+// explainClass describes the character class of the pairs of ranges ranges, by what it leaves out when it is negated.
+func explainClass(ranges []rune) string {
+	if len(ranges) == 0 {
+		return "nothing"
+	}
+	if ranges[0] == 0 && ranges[len(ranges)-1] == unicode.MaxRune {
+		// A negated class, made of what comes between the ranges
+		var excluded []rune
+		for i := 1; i+1 < len(ranges); i += 2 {
+			excluded = append(excluded, ranges[i]+1, ranges[i+1]-1)
+		}
+		if len(excluded) == 0 {
+			return "any character"
+		}
+		return "any character but " + classItems(excluded)
+	}
+	return "any of " + classItems(ranges)
+}
+
+// classItems lists the pairs of ranges ranges, as characters or as a range from one to the other.
+func classItems(ranges []rune) string {
+	var items []string
+	for i := 0; i+1 < len(ranges); i += 2 {
+		switch ranges[i+1] - ranges[i] {
+		case 0:
+			items = append(items, strconv.QuoteRune(ranges[i]))
+		case 1:
+			items = append(items, strconv.QuoteRune(ranges[i]), strconv.QuoteRune(ranges[i+1]))
+		default:
+			items = append(items, strconv.QuoteRune(ranges[i])+" to "+strconv.QuoteRune(ranges[i+1]))
+		}
+	}
+	if len(items) == 1 {
+		return items[0]
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " or " + items[len(items)-1]
+}
+
+// ordinal returns n as an English ordinal, 2nd for 2.
+func ordinal(n int) string {
+	suffix := "th"
+	if n%100 < 11 || n%100 > 13 {
+		switch n % 10 {
+		case 1:
+			suffix = "st"
+		case 2:
+			suffix = "nd"
+		case 3:
+			suffix = "rd"
+		}
+	}
+	return strconv.Itoa(n) + suffix
+}
+
+// explainSource parses src and writes what it does to w.
+func explainSource(w io.Writer, src []byte) error {
+	s := new(Sed)
+	s.Init()
+	s.posix = *posix
+	if err := s.parseScript(bytes.TrimSuffix(src, newLine)); err != nil {
+		return err
+	}
+	explainItems(w, s.scriptItems)
+	return nil
+}
+
+// explainMain is `gosed explain [-f script-file] [script...]`. The scripts of the operands are explained as one, like
+// several -e, and without operands or -f the script is read from the standard input.
+func explainMain(args []string) int {
+	flags := subcommandFlags("explain", "[-f FILE] [script...]", "Describe what each command of a sed script does in plain English. Several scripts are explained as\none, like several -e, and without any the script is read from the standard input.")
+	file := flags.String("f", "", "Read the script from `FILE`")
+	scripts, status, done := parseSubcommandArgs(flags, nil, args)
+	if done {
+		return status
+	}
+
+	var src []byte
+	var err error
+	switch {
+	case *file != "":
+		src, err = os.ReadFile(*file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading script file %s: %s\n", *file, err.Error())
+			return 2
+		}
+	case len(scripts) > 0:
+		src = []byte(strings.Join(scripts, "\n"))
+	default:
+		src, err = io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading script: %s\n", err.Error())
+			return 2
+		}
+	}
+	if err := explainSource(os.Stdout, src); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+	return 0
+}
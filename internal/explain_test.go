@@ -0,0 +1,75 @@
+// explain_test.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+package sed
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExplainSource(t *testing.T) {
+	var out strings.Builder
+	if err := explainSource(&out, []byte("s/\\(a*\\)b/\\1/2;$!N\n/x/,/y/!{\n  G:acc\n  $!d\n  /z/p\n}\n:top\n5,$t top\n")); err != nil {
+		t.Fatal(err)
+	}
+	checkString(t, "explanation", `s/\(a*\)b/\1/2
+    On every line, replace the 2nd match of the regular expression with "\1".
+    The regular expression matches "(", then "a" any number of times, or none, then ")b".
+    \( and \) are plain parentheses in gosed: write ( and ) for a group.
+    \1 is written as it is in gosed, not as the text group 1 matched: write ${1} instead, which gosed only expands with the g flag.
+$!N
+    On every line except the last line, append a newline and the next line of input to the pattern space, or when there is none write the pattern space unless -n or --posix was given and quit.
+/x/,/y/! {
+    On every line except each run of lines from a line matching /x/ through the next line matching /y/, run the commands of the block, up to the matching }.
+    /x/ matches "x".
+    /y/ matches "y".
+    G:acc
+        On those lines, append a newline and the hold register acc to the pattern space.
+    $!d
+        Of those lines, on every one except the last line, delete the pattern space and start the next cycle.
+    /z/p
+        Of those lines, on lines matching /z/, write the pattern space.
+        /z/ matches "z".
+:top
+    The label "top", where b and t commands naming it go.
+5,$t top
+    On line 5 and every line after it, if a substitution was made since the last line was read or the last t branched, go to the label "top".
+`, out.String())
+
+	out.Reset()
+	if err := explainSource(&out, []byte("s/x\\{2\\}\\+[\\(]/y\\1/g")); err != nil {
+		t.Fatal(err)
+	}
+	checkString(t, "BRE operators", `s/x\{2\}\+[\(]/y\1/g
+    On every line, replace every match of the regular expression with "y\1".
+    The regular expression matches "x{2}+(".
+    \{ and \} are plain braces in gosed: write {n}, {n,} or {n,m} to repeat.
+    \+ is a plain + in gosed: write + for one or more times.
+    \1 is written as it is in gosed, not as the text group 1 matched: write ${1} instead.
+`, out.String())
+
+	if err := explainSource(&out, []byte("y/ab/c/")); err == nil {
+		t.Error("Expected the error of the y command")
+	}
+}
+
+func TestExplainRegex(t *testing.T) {
+	tests := []struct {
+		regex, expected string
+	}{
+		{"abc", `"abc"`},
+		{"^a.b$", `the start, then "a", then any character but a newline, then "b", then the end`},
+		{"[0-9]+", `any of '0' to '9' one or more times`},
+		{"[^ \t]*", `(any character but '\t', '\n' or ' ') any number of times, or none`},
+		{"(ab|cd){2,}", `group 1 (either "ab", or "cd") at least 2 times`},
+		{"x{3}y?", `"x" exactly 3 times, then optionally "y"`},
+	}
+	for _, test := range tests {
+		checkString(t, test.regex, test.expected, explainRegex(test.regex))
+	}
+	checkString(t, "ordinals", "1st 2nd 3rd 4th 11th 12th 13th 21st 102nd", strings.Join([]string{ordinal(1), ordinal(2), ordinal(3), ordinal(4), ordinal(11), ordinal(12), ordinal(13), ordinal(21), ordinal(102)}, " "))
+}
@@ -0,0 +1,152 @@
+// fmt.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+
+// Package sed implements the entire program, from this specific part, we implement `gosed fmt`, which rewrites scripts in a canonical layout
+package sed
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// fmtIndent is what each level of block nesting is indented by.
+const fmtIndent = "    "
+
+// formatItems writes items in the canonical layout: one command per line, in canonical syntax, indented by block depth,
+// comments kept where they were, and runs of blank lines collapsed into one. Blank lines at the start or end of
+// the script or of a block are dropped. Every line starts with prefix.
+func formatItems(w io.Writer, items []scriptItem, prefix string) {
+	depth := 0
+	blank := false
+	first := true
+	for _, item := range items {
+		if item.blank {
+			blank = !first
+			continue
+		}
+		if first && item.cmd == nil && item.comment == "n" && item.pos != (scriptPosition{line: 1, column: 1}) {
+			// Moved to the first line, this comment would turn into #n and silence the script
+			blank = true
+		}
+		_, isEnd := item.cmd.(*BlockEndCmd)
+		if blank && !isEnd {
+			fmt.Fprintln(w)
+		}
+		blank = false
+		first = false
+		if isEnd && depth > 0 {
+			depth--
+		}
+		fmt.Fprint(w, prefix, strings.Repeat(fmtIndent, depth))
+		switch {
+		case item.cmd == nil:
+			fmt.Fprintf(w, "#%s\n", item.comment)
+			continue
+		case item.comment != "":
+			fmt.Fprintf(w, "%s #%s\n", item.cmd.source(), item.comment)
+		default:
+			fmt.Fprintln(w, item.cmd.source())
+		}
+		if _, ok := item.cmd.(*BlockCmd); ok {
+			depth++
+			first = true
+		}
+	}
+}
+
+// formatSource parses src and returns it in the canonical layout.
+func formatSource(src []byte) ([]byte, error) {
+	s := new(Sed)
+	s.Init()
+	s.posix = *posix
+	if err := s.parseScript(bytes.TrimSuffix(src, newLine)); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	formatItems(&buf, s.scriptItems, "")
+	return buf.Bytes(), nil
+}
+
+// fmtMain is `gosed fmt [--check] [-w] [script...]`. Without files it formats the standard input to the standard output.
+// With --check nothing is written, the files that aren't formatted are listed and the exit status is 1 if there are any.
+// With -w the files are rewritten in place.
+func fmtMain(args []string) int {
+	flags := subcommandFlags("fmt", "[--check] [-w] [script...]", "Rewrite sed scripts in a canonical layout: one command per line, blocks indented, comments kept.\nWithout files, format the standard input to the standard output.")
+	check := flags.Bool("check", false, "List the scripts that aren't formatted and exit with 1 if there are any")
+	write := flags.Bool("w", false, "Write the result back to the script files")
+	files, status, done := parseSubcommandArgs(flags, nil, args)
+	if done {
+		return status
+	}
+
+	if len(files) == 0 {
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading script: %s\n", err.Error())
+			return 2
+		}
+		out, err := formatSource(src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "<stdin>: %s\n", err.Error())
+			return 2
+		}
+		if *check {
+			if !bytes.Equal(src, out) {
+				fmt.Fprintln(os.Stdout, "<stdin>")
+				return 1
+			}
+			return 0
+		}
+		os.Stdout.Write(out)
+		return 0
+	}
+
+	status = 0
+	for _, name := range files {
+		src, err := os.ReadFile(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading script file %s: %s\n", name, err.Error())
+			status = 2
+			continue
+		}
+		out, err := formatSource(src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", name, err.Error())
+			status = 2
+			continue
+		}
+		switch {
+		case *check:
+			if !bytes.Equal(src, out) {
+				fmt.Fprintln(os.Stdout, name)
+				if status == 0 {
+					status = 1
+				}
+			}
+		case *write:
+			if bytes.Equal(src, out) {
+				continue
+			}
+			info, err := os.Stat(name)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing %s: %s\n", name, err.Error())
+				status = 2
+				continue
+			}
+			if err := os.WriteFile(name, out, info.Mode().Perm()); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing %s: %s\n", name, err.Error())
+				status = 2
+			}
+		default:
+			os.Stdout.Write(out)
+		}
+	}
+	return status
+}
@@ -0,0 +1,47 @@
+// fmt_test.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+
+package sed
+
+import (
+	"testing"
+)
+
+func TestFormatSource(t *testing.T) {
+	tests := []struct {
+		msg, src, expected string
+	}{
+		{"one command per line", "p;x ; 2d", "p\nx\n2d\n"},
+		{"blocks are indented", "/a/{s/a/b/g;/b/ {p}}", "/a/ {\n    s/a/b/g\n    /b/ {\n        p\n    }\n}\n"},
+		{"comments are kept", "# head\np  # print\n#n", "# head\np # print\n#n\n"},
+		{"#n stays on the first line", "#n\np", "#n\np\n"},
+		{"#n isn't moved to the first line", "\n#n\np", "\n#n\np\n"},
+		{"blank lines are collapsed", "\n\np\n\n\n\nx\n\n", "p\n\nx\n"},
+		{"blank lines at the edges of a block are dropped", "1{\n\np\n\n}", "1 {\n    p\n}\n"},
+		{"text is moved to its own line", "1a hello\\\nworld\n$i\\\n  bye", "1a\\\nhello\\\nworld\n$i\\\n  bye\n"},
+		{"addresses and flags are canonical", "1,$ ! s|/|x|2", "1,$!s/\\//x/2\n"},
+		{"labels and branches", ":top;/x/b top ; b", ":top\n/x/b top\nb\n"},
+	}
+	for _, test := range tests {
+		out, err := formatSource([]byte(test.src))
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.msg, err)
+			continue
+		}
+		checkString(t, test.msg, test.expected, string(out))
+		again, err := formatSource(out)
+		if err != nil {
+			t.Errorf("%s: unexpected error formatting again %v", test.msg, err)
+			continue
+		}
+		checkString(t, test.msg+", formatted twice", test.expected, string(again))
+	}
+
+	if _, err := formatSource([]byte("p\n{x")); err == nil {
+		t.Errorf("Expected an error formatting an unbalanced script")
+	}
+}
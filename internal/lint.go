@@ -0,0 +1,264 @@
+// lint.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+
+// Package sed implements the entire program, from this specific part, we implement `gosed lint`, which reports what is almost certainly a bug in a script
+package sed
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// lintDiagnostic is one problem found in a script.
+type lintDiagnostic struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// String formats the diagnostic as file:line:col: rule: message, like compilers do, so editors can jump to it.
+func (d lintDiagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s", d.File, d.Line, d.Column, d.Rule, d.Message)
+}
+
+// lintRules maps the parse errors worth a rule of their own to that rule. Every other one is a syntax error.
+var lintRules = []struct {
+	err  error
+	rule string
+}{
+	{ErrUndefinedLabel, "undefined-label"},
+	{ErrDuplicateLabel, "duplicate-label"},
+	{ErrYLengthMismatch, "y-length"},
+	{ErrNoSupportForTwoAddress, "two-address"},
+}
+
+// linter collects the diagnostics of one script.
+type linter struct {
+	file        string
+	s           *Sed
+	diagnostics []lintDiagnostic
+}
+
+func (l *linter) report(pos scriptPosition, rule, format string, args ...interface{}) {
+	l.diagnostics = append(l.diagnostics, lintDiagnostic{
+		File:    l.file,
+		Line:    pos.line,
+		Column:  pos.column,
+		Rule:    rule,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// parseError turns an error of the parser into a diagnostic, and lets the parser go on.
+func (l *linter) parseError(err *ScriptError) error {
+	rule := "syntax"
+	for _, r := range lintRules {
+		if errors.Is(err.Err, r.err) {
+			rule = r.rule
+			break
+		}
+	}
+	message := err.Err.Error()
+	if rule == "y-length" && strings.Contains(err.Text, "\\n") {
+		message += " (\\n is a single character)"
+	}
+	l.report(scriptPosition{line: err.Line, column: err.Column}, rule, "%s", message)
+	return nil
+}
+
+// lintSource parses src and returns what is wrong with it, sorted by position. file is only used to label the diagnostics.
+func lintSource(file string, src []byte) []lintDiagnostic {
+	l := &linter{file: file, s: new(Sed)}
+	l.s.Init()
+	// The script is linted for every sed: extensions are reported rather than rejected, even with POSIXLY_CORRECT set
+	l.s.parseScriptReporting(bytes.TrimSuffix(src, newLine), l.parseError)
+
+	l.checkLabels()
+	l.checkUnreachable()
+	for _, item := range l.s.scriptItems {
+		if item.cmd == nil {
+			continue
+		}
+		l.checkSubstitution(item)
+		l.checkAddress(item)
+		for _, extension := range item.extensions {
+			l.report(item.pos, "non-portable", "%s is an extension to POSIX sed", extension)
+		}
+	}
+
+	sort.SliceStable(l.diagnostics, func(i, j int) bool {
+		a, b := l.diagnostics[i], l.diagnostics[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return l.diagnostics
+}
+
+// checkLabels reports the labels no branch goes to.
+func (l *linter) checkLabels() {
+	used := make(map[string]bool)
+	for _, in := range l.s.program {
+		if branch, ok := in.cmd.(*BCmd); ok {
+			used[branch.label] = true
+		}
+	}
+	for _, in := range l.s.program {
+		if label, ok := in.cmd.(*LabelCmd); ok && !used[label.label] {
+			l.report(l.s.scriptPositions[label], "unused-label", "Label %s is never branched to", label.label)
+		}
+	}
+}
+
+// endsCycle returns the name of c when it ends every cycle it runs in, whatever the input: c, d, D, q or b without an address.
+func endsCycle(c Cmd) string {
+	if c.getAddress() != nil {
+		return ""
+	}
+	switch c := c.(type) {
+	case *DCmd:
+		if c.upToFirstNewLine {
+			return "D"
+		}
+		return "d"
+	case *CCmd:
+		return "c"
+	case *QCmd:
+		return "q"
+	case *BCmd:
+		if !c.conditional {
+			return "b"
+		}
+	}
+	return ""
+}
+
+// checkUnreachable reports the commands following a c, d, D, q or b without an address. They can't run until the end of
+// the block the command is in, unless a label lets a branch get there.
+func (l *linter) checkUnreachable() {
+	program := l.s.program
+	for i := 0; i < len(program); i++ {
+		name := endsCycle(program[i].cmd)
+		if name == "" {
+			continue
+		}
+		var dead Cmd
+		depth := 0
+	scan:
+		for j := i + 1; j < len(program); j++ {
+			switch program[j].cmd.(type) {
+			case *LabelCmd:
+				break scan
+			case *BlockEndCmd:
+				if depth == 0 {
+					break scan
+				}
+				depth--
+				continue
+			case *BlockCmd:
+				depth++
+			}
+			if dead == nil {
+				dead = program[j].cmd
+			}
+			i = j
+		}
+		if dead != nil {
+			l.report(l.s.scriptPositions[dead], "unreachable", "%s can never run, it follows an unconditional %s", dead.source(), name)
+		}
+	}
+}
+
+// checkSubstitution reports an s command replacing a plain string with itself, which changes nothing.
+func (l *linter) checkSubstitution(item scriptItem) {
+	c, ok := item.cmd.(*SCmd)
+	if !ok || c.regex != string(c.replace) || regexp.QuoteMeta(c.regex) != c.regex {
+		return
+	}
+	l.report(item.pos, "no-op-substitution", "%s replaces %s with itself, it changes nothing", c.source(), c.regex)
+}
+
+// checkAddress reports a range that ends before it starts, and ranges on the commands only POSIX limits to one address.
+func (l *linter) checkAddress(item scriptItem) {
+	addr := item.cmd.getAddress()
+	if addr == nil {
+		return
+	}
+	if addr.addressType == addressRange && addr.rangeEnd < addr.rangeStart {
+		l.report(item.pos, "range-end", "The range %s ends before it starts, it only matches line %d", addr.source(), addr.rangeStart)
+	}
+	if addr.addressType == addressSpan && addr.last.addressType == addressLine {
+		l.report(item.pos, "range-end", "The range %s ends at line %d, from then on it only matches the lines %s matches, one at a time", addr.source(), addr.last.rangeStart, addr.first.source())
+	}
+	if !addr.isRange() {
+		return
+	}
+	switch item.cmd.(type) {
+	case *ACmd, *ICmd, *RCmd, *EqlCmd:
+		cmd := item.cmd.source()[len(addr.source())]
+		l.report(item.pos, "two-address", "%s: %c (GNU sed accepts it, POSIX sed doesn't)", ErrNoSupportForTwoAddress.Error(), cmd)
+	}
+}
+
+// lintMain is `gosed lint [--json] [script...]`. Without files it lints the standard input. The diagnostics are
+// printed one per line as file:line:col: rule: message, or as a JSON array with --json. The exit status is 1 when
+// anything was found.
+func lintMain(args []string) int {
+	flags := subcommandFlags("lint", "[--json] [script...]", "Report likely bugs and non-portable extensions of sed scripts as file:line:col: rule: message, and exit\nwith status 1 when there are any. Without files, lint the standard input.")
+	asJSON := flags.Bool("json", false, "Print the diagnostics as a JSON array")
+	files, status, done := parseSubcommandArgs(flags, nil, args)
+	if done {
+		return status
+	}
+
+	status = 0
+	diagnostics := []lintDiagnostic{}
+	lint := func(name string, src []byte) {
+		found := lintSource(name, src)
+		diagnostics = append(diagnostics, found...)
+		if len(found) > 0 && status == 0 {
+			status = 1
+		}
+	}
+	if len(files) == 0 {
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading script: %s\n", err.Error())
+			return 2
+		}
+		lint("<stdin>", src)
+	}
+	for _, name := range files {
+		src, err := os.ReadFile(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading script file %s: %s\n", name, err.Error())
+			status = 2
+			continue
+		}
+		lint(name, src)
+	}
+
+	if *asJSON {
+		out, _ := json.MarshalIndent(diagnostics, "", "  ")
+		fmt.Fprintln(os.Stdout, string(out))
+	} else {
+		for _, d := range diagnostics {
+			fmt.Fprintln(os.Stdout, d.String())
+		}
+	}
+	return status
+}
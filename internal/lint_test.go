@@ -0,0 +1,53 @@
+// lint_test.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+
+package sed
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		msg, src string
+		expected []string
+	}{
+		{"a clean script", "# swap\n/x/{\n    x\n    p\n}\n", nil},
+		{"undefined label", "b nowhere", []string{"t.sed:1:1: undefined-label: Can't find label for jump: nowhere"}},
+		{"unused label", ":top\np", []string{"t.sed:1:1: unused-label: Label top is never branched to"}},
+		{"duplicate label", ":a\n:a\nba", []string{"t.sed:2:1: duplicate-label: Label defined more than once"}},
+		{"unreachable after d", "p\nd\nx\ns/a/b/", []string{"t.sed:3:1: unreachable: x can never run, it follows an unconditional d"}},
+		{"reachable through a label", "b end\np\n:end\nx", []string{"t.sed:2:1: unreachable: p can never run, it follows an unconditional b"}},
+		{"unreachable ends with the block", "/x/{\n    d\n    p\n}\nx", []string{"t.sed:3:5: unreachable: p can never run, it follows an unconditional d"}},
+		{"d with an address", "/x/d\np", nil},
+		{"identical s", "s/foo/foo/g", []string{"t.sed:1:1: no-op-substitution: s/foo/foo/g replaces foo with itself, it changes nothing"}},
+		{"s with a regex", "s/a.c/a.c/", nil},
+		{"range ending before it starts", "5,2p", []string{"t.sed:1:1: range-end: The range 5,2 ends before it starts, it only matches line 5"}},
+		{"range ending at a line number", "/a/,2p", []string{"t.sed:1:1: range-end: The range /a/,2 ends at line 2, from then on it only matches the lines /a/ matches, one at a time"}},
+		{"range ending at a regex", "2,/a/p;/a/,$p", nil},
+		{"two addresses on =", "1,3=", []string{"t.sed:1:1: two-address: This command doesn't support an address range or to end of file: = (GNU sed accepts it, POSIX sed doesn't)"}},
+		{"two addresses on q", "p;1,3q", []string{"t.sed:1:3: two-address: This command doesn't support an address range or to end of file: q"}},
+		{"y with \\n", "y/a\\nb/xy/", []string{"t.sed:1:1: y-length: Strings for y command are different lengths: 3 characters to replace, 2 to replace them with (\\n is a single character)"}},
+		{"extensions", "q5\n:a;ta\np # c", []string{
+			"t.sed:1:1: non-portable: exit code for q is an extension to POSIX sed",
+			"t.sed:2:1: non-portable: label ended by ; is an extension to POSIX sed",
+			"t.sed:3:1: non-portable: comment after a command is an extension to POSIX sed",
+		}},
+		{"syntax errors don't stop the linter", "p x\nb nowhere", []string{
+			"t.sed:1:1: syntax: Extra characters after command",
+			"t.sed:2:1: undefined-label: Can't find label for jump: nowhere",
+		}},
+	}
+	for _, test := range tests {
+		var found []string
+		for _, d := range lintSource("t.sed", []byte(test.src)) {
+			found = append(found, d.String())
+		}
+		checkString(t, test.msg, strings.Join(test.expected, "\n"), strings.Join(found, "\n"))
+	}
+}
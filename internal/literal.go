@@ -0,0 +1,109 @@
+// literal.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+
+// Package sed implements the entire program, from this specific part, we find the literal text a regex can't match without, so lines lacking it are skipped without running the regex
+package sed
+
+import (
+	"bytes"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+)
+
+// regexLiteral is what a regex tells about the lines it can match, found once when the script is parsed.
+type regexLiteral struct {
+	required []byte // every match contains it, nil when no such literal was found
+	pure     bool   // the regex is required and nothing else, it matches exactly where required is
+}
+
+// analyzeRegex finds the longest literal every match of pattern contains. pattern is parsed the way
+// regexp.CompilePOSIX parses it, which has already accepted it.
+func analyzeRegex(pattern string) regexLiteral {
+	re, err := syntax.Parse(pattern, syntax.POSIX)
+	if err != nil {
+		return regexLiteral{}
+	}
+	re = re.Simplify()
+	if re.Op == syntax.OpLiteral && re.Flags&syntax.FoldCase == 0 && usableLiteral(string(re.Rune)) {
+		return regexLiteral{required: []byte(string(re.Rune)), pure: true}
+	}
+	if lit := requiredLiteral(re); usableLiteral(lit) {
+		return regexLiteral{required: []byte(lit)}
+	}
+	return regexLiteral{}
+}
+
+// usableLiteral reports whether lit can be looked for with bytes.Index. The regexp package matches a U+FFFD of
+// the pattern against bytes that aren't UTF-8, which bytes.Index wouldn't find.
+func usableLiteral(lit string) bool {
+	return lit != "" && !strings.ContainsRune(lit, utf8.RuneError)
+}
+
+// exactLiteral returns the only text re matches, if it only matches one.
+func exactLiteral(re *syntax.Regexp) (string, bool) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return "", false
+		}
+		return string(re.Rune), true
+	case syntax.OpEmptyMatch:
+		return "", true
+	case syntax.OpCapture:
+		return exactLiteral(re.Sub[0])
+	case syntax.OpConcat:
+		var sb strings.Builder
+		for _, sub := range re.Sub {
+			lit, ok := exactLiteral(sub)
+			if !ok {
+				return "", false
+			}
+			sb.WriteString(lit)
+		}
+		return sb.String(), true
+	}
+	return "", false
+}
+
+// requiredLiteral returns the longest literal found in every match of re, or nothing.
+func requiredLiteral(re *syntax.Regexp) string {
+	if lit, ok := exactLiteral(re); ok {
+		return lit
+	}
+	switch re.Op {
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiteral(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min > 0 {
+			return requiredLiteral(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		// Adjacent exact parts make one literal, every other part may require one of its own
+		best, run := "", ""
+		for _, sub := range re.Sub {
+			if lit, ok := exactLiteral(sub); ok {
+				run += lit
+			} else {
+				run = ""
+				if lit := requiredLiteral(sub); len(lit) > len(best) {
+					best = lit
+				}
+			}
+			if len(run) > len(best) {
+				best = run
+			}
+		}
+		return best
+	}
+	return ""
+}
+
+// rejects reports whether line can't match, because it lacks the required literal.
+func (l *regexLiteral) rejects(line []byte) bool {
+	return l.required != nil && !bytes.Contains(line, l.required)
+}
@@ -0,0 +1,61 @@
+// literal_test.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+package sed
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestAnalyzeRegex(t *testing.T) {
+	for _, test := range []struct {
+		pattern, required string
+		pure              bool
+	}{
+		{"GET", "GET", true},
+		{"a\\.b", "a.b", true},
+		{"(GET)", "GET", false},
+		{"^GET /", "GET /", false},
+		{"[0-9]+ 404 [0-9]+$", " 404 ", false},
+		{"(foo)bar", "foobar", false},
+		{"x(abc)+y", "abc", false},
+		{"a.*longer", "longer", false},
+		{"abc|abd", "ab", false},
+		{"foo|bar", "", false},
+		{"a*", "", false},
+		{"(abc)?d", "d", false},
+		{".", "", false},
+	} {
+		lit := analyzeRegex(test.pattern)
+		checkString(t, test.pattern, test.required, string(lit.required))
+		if lit.pure != test.pure {
+			t.Errorf("%s: expected pure to be %v", test.pattern, test.pure)
+		}
+	}
+}
+
+// TestLiteralPrefilter runs addresses and s commands with and without what analyzeRegex found, they must agree.
+func TestLiteralPrefilter(t *testing.T) {
+	lines := []string{"", "GET x/index.html 200 GET", "POST /api 404 12", "get lowercase", "abd abc ab", "aaaa", "x\xffy", "a\nGET"}
+	for _, pattern := range []string{"GET", "GET x", "[0-9]+ 404 [0-9]+$", "abc|abd", "aa", "(a)(a)", "^a", "x.y"} {
+		re := regexp.MustCompilePOSIX(pattern)
+		addr := &address{addressType: addressRegEx, regex: re, literal: analyzeRegex(pattern)}
+		for _, line := range lines {
+			if addr.match([]byte(line), 1) != re.MatchString(line) {
+				t.Errorf("/%s/ on %q: the prefilter changes the match", pattern, line)
+			}
+			for _, flag := range []string{"g", "", "2"} {
+				for _, replace := range []string{"[X]", "<${0}>"} {
+					script := "s/" + pattern + "/" + replace + "/" + flag
+					plain := *mustCmd(t, script).(*SCmd)
+					plain.literal = regexLiteral{}
+					checkString(t, script+" on "+line, runSedWith(t, script, line, func(s *Sed) { s.program[0].cmd = &plain }), runSed(t, script, line))
+				}
+			}
+		}
+	}
+}
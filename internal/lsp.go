@@ -0,0 +1,577 @@
+// lsp.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+
+// Package sed implements the entire program, from this specific part, we implement `gosed lsp`, a Language Server Protocol server that gives editors the diagnostics of the linter, documentation, labels and formatting
+package sed
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Manual is the POSIX manual page of sed, the sed.html at the root of the repository, which main sets. gosed lsp
+// takes the documentation of the commands from it.
+var Manual []byte
+
+// JSON-RPC error codes
+const (
+	lspMethodNotFound = -32601
+	lspRequestFailed  = -32803
+)
+
+// LSP symbol kinds and diagnostic severities
+const (
+	lspSymbolNamespace = 3
+	lspSymbolFunction  = 12
+	lspSeverityError   = 1
+	lspSeverityWarning = 2
+)
+
+// lspMessage is a JSON-RPC request, or a notification when it has no ID.
+type lspMessage struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *lspError) Error() string {
+	return e.Message
+}
+
+// lspPosition is a position in a document. Line and Character count from 0, Character in UTF-16 code units.
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Code     string   `json:"code"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspSymbol struct {
+	Name           string       `json:"name"`
+	Detail         string       `json:"detail,omitempty"`
+	Kind           int          `json:"kind"`
+	Range          lspRange     `json:"range"`
+	SelectionRange lspRange     `json:"selectionRange"`
+	Children       []*lspSymbol `json:"children,omitempty"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+// lspDocumentParams are the parameters of every request on a position of a document, and of those on a whole one.
+type lspDocumentParams struct {
+	TextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+	Position       lspPosition `json:"position"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+// lspServer answers the requests of one editor on in, writing to out.
+type lspServer struct {
+	in       *bufio.Reader
+	out      io.Writer
+	docs     map[string]string // the open documents by URI
+	shutdown bool
+}
+
+// lspErrorRules are the rules of the linter that are errors, the script doesn't run. The others are warnings.
+var lspErrorRules = map[string]bool{"syntax": true, "undefined-label": true, "duplicate-label": true, "y-length": true}
+
+// serve answers requests until the editor asks to exit, and returns the exit status: 0 after a shutdown, 1 otherwise.
+func (l *lspServer) serve() int {
+	for {
+		body, err := l.read()
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintf(os.Stderr, "gosed lsp: %s\n", err.Error())
+			}
+			return 1
+		}
+		var msg lspMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			fmt.Fprintf(os.Stderr, "gosed lsp: %s\n", err.Error())
+			continue
+		}
+		if msg.Method == "exit" {
+			if l.shutdown {
+				return 0
+			}
+			return 1
+		}
+		result, err := l.handle(msg)
+		if msg.ID == nil {
+			continue
+		}
+		response := map[string]any{"jsonrpc": "2.0", "id": msg.ID}
+		var rpcErr *lspError
+		switch {
+		case errors.As(err, &rpcErr):
+			response["error"] = rpcErr
+		case err != nil:
+			response["error"] = lspError{Code: lspRequestFailed, Message: err.Error()}
+		default:
+			response["result"] = result
+		}
+		if err := l.write(response); err != nil {
+			fmt.Fprintf(os.Stderr, "gosed lsp: %s\n", err.Error())
+			return 1
+		}
+	}
+}
+
+// read reads the body of the next message, which comes after a Content-Length header and a blank line.
+func (l *lspServer) read() ([]byte, error) {
+	length := -1
+	for {
+		header, err := l.in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		header = strings.TrimSpace(header)
+		if header == "" {
+			break
+		}
+		if name, value, ok := strings.Cut(header, ":"); ok && strings.EqualFold(name, "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("bad Content-Length: %w", err)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("message without a Content-Length")
+	}
+	body := make([]byte, length)
+	_, err := io.ReadFull(l.in, body)
+	return body, err
+}
+
+// write sends msg to the editor.
+func (l *lspServer) write(msg any) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(l.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+// handle runs the request or notification msg, and returns the result of a request.
+func (l *lspServer) handle(msg lspMessage) (any, error) {
+	var params lspDocumentParams
+	if len(msg.Params) > 0 {
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+	}
+	uri := params.TextDocument.URI
+	switch msg.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":           1, // the whole document on every change
+				"hoverProvider":              true,
+				"definitionProvider":         true,
+				"documentSymbolProvider":     true,
+				"documentFormattingProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "gosed"},
+		}, nil
+	case "shutdown":
+		l.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		l.docs[uri] = params.TextDocument.Text
+		return nil, l.publishDiagnostics(uri)
+	case "textDocument/didChange":
+		if n := len(params.ContentChanges); n > 0 {
+			l.docs[uri] = params.ContentChanges[n-1].Text
+		}
+		return nil, l.publishDiagnostics(uri)
+	case "textDocument/didClose":
+		// An empty document clears the diagnostics
+		delete(l.docs, uri)
+		return nil, l.publishDiagnostics(uri)
+	case "textDocument/hover":
+		return lspHover(l.docs[uri], params.Position), nil
+	case "textDocument/definition":
+		return lspDefinition(uri, l.docs[uri], params.Position), nil
+	case "textDocument/documentSymbol":
+		return lspSymbols(l.docs[uri]), nil
+	case "textDocument/formatting":
+		return lspFormat(l.docs[uri])
+	}
+	if msg.ID != nil {
+		return nil, &lspError{Code: lspMethodNotFound, Message: "Unknown method " + msg.Method}
+	}
+	return nil, nil
+}
+
+// publishDiagnostics sends the editor what the linter finds in the document uri.
+func (l *lspServer) publishDiagnostics(uri string) error {
+	return l.write(map[string]any{
+		"jsonrpc": "2.0",
+		"method":  "textDocument/publishDiagnostics",
+		"params":  map[string]any{"uri": uri, "diagnostics": lspDiagnostics(l.docs[uri])},
+	})
+}
+
+// lspDiagnostics returns the diagnostics of the linter for text, each spanning the command it is about.
+func lspDiagnostics(text string) []lspDiagnostic {
+	lines := docLines(text)
+	diagnostics := []lspDiagnostic{}
+	for _, d := range lintSource("", []byte(text)) {
+		severity := lspSeverityWarning
+		if lspErrorRules[d.Rule] {
+			severity = lspSeverityError
+		}
+		diagnostics = append(diagnostics, lspDiagnostic{
+			Range:    commandRange(lines, scriptPosition{line: d.Line, column: d.Column}),
+			Severity: severity,
+			Code:     d.Rule,
+			Source:   "gosed",
+			Message:  d.Message,
+		})
+	}
+	return diagnostics
+}
+
+// lspHover returns the documentation of the command at pos, or of addresses on an address, nil elsewhere.
+func lspHover(text string, pos lspPosition) any {
+	lines := docLines(text)
+	item, ok := itemAt(parseDocument(text), lines, pos)
+	if !ok {
+		return nil
+	}
+	line := lines[item.pos.line-1][item.pos.column-1:]
+	letter, err := skipAddresses([]byte(line))
+	if err != nil || letter >= len(line) {
+		return nil
+	}
+	var doc string
+	if byteColumn(lines[pos.Line], pos.Character) < item.pos.column-1+letter {
+		doc = manualDocs().addresses
+	} else if def, _ := registeredCommand([]byte(line[letter:])); def != nil {
+		doc = fmt.Sprintf("`%s`\n\nA command registered from Go.", def.Name)
+	} else if doc = manualDocs().commands[line[letter]]; doc == "" {
+		doc = extensionDocs[line[letter]]
+	}
+	if doc == "" {
+		return nil
+	}
+	return map[string]any{
+		"contents": map[string]string{"kind": "markdown", "value": doc},
+		"range":    commandRange(lines, item.pos),
+	}
+}
+
+// extensionDocs documents the commands of gosed the POSIX manual doesn't have.
+var extensionDocs = map[byte]string{
+	'm': "`[2addr]m replacement-table`\n\nReplace, in a single pass, the old text of every old<TAB>new line of the file replacement-table with the new. An extension of gosed.",
+}
+
+// lspDefinition returns where the label the branch at pos goes to is defined, nil when pos isn't on a branch to a label.
+func lspDefinition(uri, text string, pos lspPosition) any {
+	lines := docLines(text)
+	s := parseDocument(text)
+	item, ok := itemAt(s, lines, pos)
+	if !ok {
+		return nil
+	}
+	branch, ok := item.cmd.(*BCmd)
+	if !ok || branch.label == "" {
+		return nil
+	}
+	for _, other := range s.scriptItems {
+		if label, ok := other.cmd.(*LabelCmd); ok && label.label == branch.label {
+			return lspLocation{URI: uri, Range: commandRange(lines, other.pos)}
+		}
+	}
+	return nil
+}
+
+// lspSymbols returns the labels and blocks of text, nested the way they are in the script: the labels and blocks
+// inside a block are its children. Other commands aren't symbols.
+func lspSymbols(text string) []*lspSymbol {
+	lines := docLines(text)
+	roots := []*lspSymbol{}
+	var open []*lspSymbol
+	add := func(sym *lspSymbol) {
+		if len(open) == 0 {
+			roots = append(roots, sym)
+		} else {
+			parent := open[len(open)-1]
+			parent.Children = append(parent.Children, sym)
+		}
+	}
+	for _, item := range parseDocument(text).scriptItems {
+		switch c := item.cmd.(type) {
+		case *LabelCmd:
+			r := commandRange(lines, item.pos)
+			add(&lspSymbol{Name: c.label, Detail: "label", Kind: lspSymbolFunction, Range: r, SelectionRange: r})
+		case *BlockCmd:
+			r := commandRange(lines, item.pos)
+			sym := &lspSymbol{Name: c.source(), Detail: "block", Kind: lspSymbolNamespace, Range: r, SelectionRange: r}
+			add(sym)
+			open = append(open, sym)
+		case *BlockEndCmd:
+			if len(open) > 0 {
+				open[len(open)-1].Range.End = commandRange(lines, item.pos).End
+				open = open[:len(open)-1]
+			}
+		}
+	}
+	// The blocks left open run to the end of the script
+	for _, sym := range open {
+		sym.Range.End = documentEnd(lines)
+	}
+	return roots
+}
+
+// lspFormat returns the edit putting text in the layout of gosed fmt, none when it already is.
+func lspFormat(text string) ([]lspTextEdit, error) {
+	formatted, err := formatSource([]byte(text))
+	if err != nil {
+		return nil, err
+	}
+	if string(formatted) == text {
+		return []lspTextEdit{}, nil
+	}
+	lines := docLines(text)
+	return []lspTextEdit{{Range: lspRange{End: documentEnd(lines)}, NewText: string(formatted)}}, nil
+}
+
+// parseDocument parses text as much as it can, the way the linter does, and returns the result.
+func parseDocument(text string) *Sed {
+	s := new(Sed)
+	s.Init()
+	s.parseScriptReporting(bytes.TrimSuffix([]byte(text), newLine), func(*ScriptError) error { return nil })
+	return s
+}
+
+// itemAt returns the command of s at pos, the last one starting on its line at or before it.
+func itemAt(s *Sed, lines []string, pos lspPosition) (scriptItem, bool) {
+	if pos.Line >= len(lines) {
+		return scriptItem{}, false
+	}
+	column := byteColumn(lines[pos.Line], pos.Character) + 1
+	var found scriptItem
+	ok := false
+	for _, item := range s.scriptItems {
+		if item.cmd != nil && item.pos.line == pos.Line+1 && item.pos.column <= column && item.pos.column-1 < len(lines[pos.Line]) {
+			found, ok = item, true
+		}
+	}
+	return found, ok
+}
+
+// docLines splits text into its lines, without their line endings.
+func docLines(text string) []string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// commandRange returns the range of the command at pos, a 1-based line and byte column, up to the end of the line
+// when it doesn't parse.
+func commandRange(lines []string, pos scriptPosition) lspRange {
+	if pos.line < 1 || pos.line > len(lines) {
+		return lspRange{}
+	}
+	line := lines[pos.line-1]
+	start := min(max(pos.column-1, 0), len(line))
+	end := len(line)
+	if n, err := new(Sed).commandLength([]byte(line[start:])); err == nil && n > 0 {
+		end = start + n
+	}
+	return lspRange{
+		Start: lspPosition{Line: pos.line - 1, Character: utf16Column(line, start)},
+		End:   lspPosition{Line: pos.line - 1, Character: utf16Column(line, end)},
+	}
+}
+
+// documentEnd returns the position at the end of the document of lines.
+func documentEnd(lines []string) lspPosition {
+	last := len(lines) - 1
+	return lspPosition{Line: last, Character: utf16Column(lines[last], len(lines[last]))}
+}
+
+// utf16Column returns the UTF-16 code units before the byte column of line, the character of a position.
+func utf16Column(line string, column int) int {
+	n := 0
+	for _, r := range line[:column] {
+		n += utf16Len(r)
+	}
+	return n
+}
+
+// byteColumn is the reverse of utf16Column.
+func byteColumn(line string, character int) int {
+	n := 0
+	for i, r := range line {
+		if n >= character {
+			return i
+		}
+		n += utf16Len(r)
+	}
+	return len(line)
+}
+
+// utf16Len returns the UTF-16 code units r is encoded in.
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// manualSections is the documentation found in Manual.
+type manualSections struct {
+	commands  map[byte]string // by the character the command starts with
+	addresses string
+}
+
+var parsedManual *manualSections
+
+// manualDocs returns the documentation of the commands and addresses in Manual, parsed the first time.
+func manualDocs() *manualSections {
+	if parsedManual == nil {
+		parsedManual = parseManual(Manual)
+	}
+	return parsedManual
+}
+
+var (
+	manualTags       = regexp.MustCompile(`<[^>]*>`)
+	manualParagraphs = regexp.MustCompile(`(?i)</?P>|<BR>`)
+)
+
+// parseManual takes the documentation of each command out of the "Editing Commands in sed" section of the manual
+// page, and the one of addresses out of "Addresses in sed", in markdown.
+func parseManual(manual []byte) *manualSections {
+	docs := &manualSections{commands: make(map[byte]string)}
+	text := latin1(manual)
+	if start := strings.Index(text, "Addresses in sed</H5>"); start >= 0 {
+		body := text[start+len("Addresses in sed</H5>"):]
+		if end := strings.Index(body, "<H5>"); end >= 0 {
+			docs.addresses = "**Addresses**\n\n" + manualText(body[:end])
+		}
+	}
+	start, end := strings.Index(text, "Editing Commands in sed</H5>"), strings.Index(text, "EXIT STATUS")
+	if start < 0 || end < start {
+		return docs
+	}
+	var letter byte
+	var entry strings.Builder
+	done := func() {
+		if _, ok := docs.commands[letter]; letter != 0 && !ok {
+			docs.commands[letter] = strings.TrimSpace(entry.String())
+		}
+	}
+	for i, part := range strings.Split(text[start:end], "<DT>") {
+		if i == 0 {
+			continue
+		}
+		term, description, _ := strings.Cut(part, "</DT>")
+		synopsis := manualText(term)
+		if strings.HasPrefix(synopsis, "[") || synopsis == "}" {
+			// A new command, the terms that aren't are parts of the one before: the text of a, the flags of s
+			done()
+			letter = 0
+			entry.Reset()
+			if _, rest, _ := strings.Cut(synopsis, "]"); synopsis == "}" {
+				letter = '}'
+			} else if rest = strings.TrimSpace(rest); rest != "" {
+				letter = rest[0]
+			}
+			fmt.Fprintf(&entry, "`%s`\n\n", synopsis)
+		} else {
+			fmt.Fprintf(&entry, "\n\n**%s** ", synopsis)
+		}
+		entry.WriteString(manualText(description))
+	}
+	done()
+	return docs
+}
+
+// manualText turns a piece of the manual page into plain text, paragraphs separated by blank lines.
+func manualText(markup string) string {
+	markup = manualParagraphs.ReplaceAllString(markup, "\n\n")
+	markup = html.UnescapeString(manualTags.ReplaceAllString(markup, ""))
+	var paragraphs []string
+	for _, p := range strings.Split(markup, "\n\n") {
+		if p = strings.Join(strings.Fields(p), " "); p != "" {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// latin1 returns b, which is ISO-8859-1 unless it is valid UTF-8, as a string.
+func latin1(b []byte) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// lspMain is `gosed lsp`, a Language Server Protocol server on the standard input and output.
+func lspMain(args []string) int {
+	flags := subcommandFlags("lsp", "", "Run a Language Server Protocol server for editors on stdin and stdout: diagnostics, hover docs, go to\nlabel, document symbols and formatting.")
+	operands, status, done := parseSubcommandArgs(flags, nil, args)
+	if done {
+		return status
+	}
+	if len(operands) > 0 {
+		fmt.Fprintf(os.Stderr, "error, gosed lsp takes no arguments, it talks to an editor on stdin and stdout\n")
+		return 2
+	}
+	l := &lspServer{in: bufio.NewReader(os.Stdin), out: os.Stdout, docs: make(map[string]string)}
+	return l.serve()
+}
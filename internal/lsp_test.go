@@ -0,0 +1,154 @@
+// lsp_test.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+package sed
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// lspSession runs the server on the requests, each a method and its params, with the IDs 1, 2 and so on for those
+// with a params that isn't nil, and returns the messages it sent, by ID for the responses and by method for the others.
+func lspSession(t *testing.T, requests ...any) (int, map[string]json.RawMessage) {
+	var in bytes.Buffer
+	id := 0
+	for i := 0; i < len(requests); i += 2 {
+		msg := map[string]any{"jsonrpc": "2.0", "method": requests[i]}
+		if requests[i+1] != nil {
+			msg["params"] = requests[i+1]
+		}
+		if !strings.HasPrefix(requests[i].(string), "textDocument/did") && requests[i] != "exit" {
+			id++
+			msg["id"] = id
+		}
+		body, _ := json.Marshal(msg)
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+	var out bytes.Buffer
+	l := &lspServer{in: bufio.NewReader(&in), out: &out, docs: make(map[string]string)}
+	status := l.serve()
+
+	sent := make(map[string]json.RawMessage)
+	r := bufio.NewReader(&out)
+	for {
+		l.in = r
+		body, err := l.read()
+		if err != nil {
+			break
+		}
+		var msg struct {
+			ID     json.RawMessage
+			Method string
+			Params json.RawMessage
+			Result json.RawMessage
+			Error  json.RawMessage
+		}
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case msg.Method != "":
+			sent[msg.Method] = msg.Params
+		case msg.Error != nil:
+			sent[string(msg.ID)] = msg.Error
+		default:
+			sent[string(msg.ID)] = msg.Result
+		}
+	}
+	return status, sent
+}
+
+func TestLSP(t *testing.T) {
+	manual, err := os.ReadFile("../sed.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := Manual
+	Manual, parsedManual = manual, nil
+	defer func() { Manual, parsedManual = saved, nil }()
+
+	const uri = "file:///x.sed"
+	script := ":top\n/é/{\ns/a/b/;b top\n  p\n}\ny/ab/c/\n"
+	at := func(line, character int) map[string]any {
+		return map[string]any{"textDocument": map[string]string{"uri": uri}, "position": lspPosition{line, character}}
+	}
+	doc := map[string]any{"textDocument": map[string]string{"uri": uri}}
+	status, sent := lspSession(t,
+		"initialize", map[string]any{},
+		"textDocument/didOpen", map[string]any{"textDocument": map[string]string{"uri": uri, "text": script}},
+		"textDocument/hover", at(2, 7),
+		"textDocument/hover", at(1, 0),
+		"textDocument/definition", at(2, 9),
+		"textDocument/documentSymbol", doc,
+		"textDocument/formatting", doc,
+		"workspace/symbol", map[string]any{},
+		"shutdown", nil,
+		"exit", nil,
+	)
+	checkInt(t, status, 0, "exit status")
+
+	var diagnostics struct {
+		Diagnostics []lspDiagnostic
+	}
+	json.Unmarshal(sent["textDocument/publishDiagnostics"], &diagnostics)
+	if d := diagnostics.Diagnostics; len(d) != 2 || d[0].Code != "unreachable" || d[0].Severity != lspSeverityWarning || d[1].Code != "y-length" || d[1].Severity != lspSeverityError {
+		t.Errorf("Expected an unreachable warning and a y-length error, got %s", sent["textDocument/publishDiagnostics"])
+	} else if r := d[1].Range; r != (lspRange{lspPosition{5, 0}, lspPosition{5, 7}}) {
+		t.Errorf("Bad range of the diagnostic: %v", r)
+	}
+
+	var hover struct {
+		Contents struct{ Value string }
+		Range    lspRange
+	}
+	json.Unmarshal(sent["2"], &hover)
+	if !strings.HasPrefix(hover.Contents.Value, "`[2addr]b [label]`\n\nBranch to the : command bearing the label.") {
+		t.Errorf("Bad hover of b: %q", hover.Contents.Value)
+	}
+	if hover.Range != (lspRange{lspPosition{2, 7}, lspPosition{2, 12}}) {
+		t.Errorf("Bad range of the hover of b: %v", hover.Range)
+	}
+	json.Unmarshal(sent["3"], &hover)
+	if !strings.HasPrefix(hover.Contents.Value, "**Addresses**\n\nAn address is either") {
+		t.Errorf("Bad hover of an address: %q", hover.Contents.Value)
+	}
+
+	checkString(t, "definition", `{"uri":"file:///x.sed","range":{"start":{"line":0,"character":0},"end":{"line":0,"character":4}}}`, string(sent["4"]))
+
+	var symbols []*lspSymbol
+	json.Unmarshal(sent["5"], &symbols)
+	if len(symbols) != 2 || symbols[0].Name != "top" || symbols[1].Name != "/é/ {" || symbols[1].Range.End != (lspPosition{4, 1}) {
+		t.Errorf("Bad symbols: %s", sent["5"])
+	}
+
+	if !strings.HasPrefix(string(sent["6"]), `{"code":-32803,"message":"Script error: Strings for y command are different lengths`) {
+		t.Errorf("Expected the parse error from formatting, got %s", sent["6"])
+	}
+	checkString(t, "unknown method", `{"code":-32601,"message":"Unknown method workspace/symbol"}`, string(sent["7"]))
+	checkString(t, "shutdown", "null", string(sent["8"]))
+}
+
+func TestParseManual(t *testing.T) {
+	manual, err := os.ReadFile("../sed.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := parseManual(manual)
+	for _, c := range "{}abcdDgGhHilnNpPqrstwxy!:=#" {
+		if docs.commands[byte(c)] == "" {
+			t.Errorf("No documentation for %c", c)
+		}
+	}
+	if s := docs.commands['s']; !strings.Contains(s, "**g** Globally substitute") {
+		t.Errorf("The flags of s are missing from its documentation:\n%s", s)
+	}
+}
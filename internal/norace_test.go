@@ -0,0 +1,13 @@
+// norace_test.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+
+//go:build !race
+
+package sed
+
+// raceEnabled tells whether the tests run under the race detector, whose instrumentation allocates.
+const raceEnabled = false
@@ -0,0 +1,161 @@
+// observe.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+
+// Package sed implements the entire program, from this specific part, we let Go code run scripts and watch what they do, for tracing and the like
+package sed
+
+import (
+	"bufio"
+	"io"
+)
+
+// Observer is told what a run of the script does, once it is added with AddObserver. Embed NopObserver to only
+// implement the methods of interest. The ExecContext is the same as the one of registered commands, and must only be
+// read from.
+type Observer interface {
+	// CycleStart is called when a cycle starts, with the line it runs on in the pattern space.
+	CycleStart(ctx *ExecContext)
+	// Command is called before each command runs, once its address matched.
+	Command(ctx *ExecContext, cmd CommandInfo)
+	// Substitution is called for each match an s or m command replaces, the command of the last call to Command.
+	// start is where old was in the pattern space before the command ran.
+	Substitution(ctx *ExecContext, start int, old, replacement []byte)
+	// Write is called for each line written to the output, without its line ending.
+	Write(ctx *ExecContext, line []byte)
+	// Branch is called when a b or t command jumps, to label, which is "" for the end of the script.
+	Branch(ctx *ExecContext, cmd CommandInfo, label string)
+	// CycleEnd is called once a cycle is over and its output written.
+	CycleEnd(ctx *ExecContext)
+}
+
+// NopObserver is an Observer that does nothing, to be embedded by those that only want some of the calls.
+type NopObserver struct{}
+
+func (NopObserver) CycleStart(*ExecContext)                        {}
+func (NopObserver) Command(*ExecContext, CommandInfo)              {}
+func (NopObserver) Substitution(*ExecContext, int, []byte, []byte) {}
+func (NopObserver) Write(*ExecContext, []byte)                     {}
+func (NopObserver) Branch(*ExecContext, CommandInfo, string)       {}
+func (NopObserver) CycleEnd(*ExecContext)                          {}
+
+// CommandInfo describes a command of the script to an Observer.
+type CommandInfo struct {
+	String string // the command as its String method describes it
+	Source string // the command in canonical sed syntax
+	Line   int    // where the command starts in the script, from 1
+	Column int
+}
+
+// New returns a Sed ready to Run script. It runs as sed does without options: the pattern space is written at the end
+// of each cycle unless the script starts with #n, and the extensions to POSIX sed are allowed.
+func New(script []byte) (*Sed, error) {
+	s := new(Sed)
+	s.Init()
+	if err := s.parseScript(script); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Run runs the script over in, writing the output to out, and returns once the input runs out or a command quits.
+// The hold space and line numbers carry on from one Run to the next.
+func (s *Sed) Run(in io.Reader, out io.Writer) error {
+	s.input = bufio.NewReader(in)
+	s.output = bufio.NewWriterSize(out, 64*1024)
+	s.quit = false
+	return s.run()
+}
+
+// AddObserver makes o be told what the script does from now on, one call after the other in the order things happen.
+func (s *Sed) AddObserver(o Observer) {
+	s.observe(addedObserver{Observer: o})
+}
+
+// runObserver is an Observer with the calls only the observers of gosed itself need: --debug, --stats and --json.
+// All of them are told what happens through the same list, s.observers.
+type runObserver interface {
+	Observer
+	// ran is called after the command of the instruction pc ran, stop telling whether it ended the cycle. It is
+	// also called when the command failed.
+	ran(ctx *ExecContext, pc int, cmd Cmd, stop bool)
+	// scriptEnd is called once the script is done with a cycle, before the pattern space is written.
+	scriptEnd(ctx *ExecContext)
+	// beginFile is called before the input file name is processed.
+	beginFile(ctx *ExecContext, name string)
+	// endFile is called once the current input file is processed.
+	endFile(ctx *ExecContext) error
+	// printed is called for each line p or P prints, the command of the last call to Command.
+	printed(ctx *ExecContext, text []byte)
+}
+
+// observerHooks are the calls of runObserver that Observer doesn't have, doing nothing, to be embedded like
+// NopObserver.
+type observerHooks struct{}
+
+func (observerHooks) ran(*ExecContext, int, Cmd, bool) {}
+func (observerHooks) scriptEnd(*ExecContext)           {}
+func (observerHooks) beginFile(*ExecContext, string)   {}
+func (observerHooks) endFile(*ExecContext) error       { return nil }
+func (observerHooks) printed(*ExecContext, []byte)     {}
+
+// addedObserver is an Observer added with AddObserver, which only gets the calls of Observer.
+type addedObserver struct {
+	Observer
+	observerHooks
+}
+
+// observe adds o to the observers of s.
+func (s *Sed) observe(o runObserver) {
+	s.observers = append(s.observers, o)
+}
+
+// setStats makes st count what the script does, for --stats and --report.
+func (s *Sed) setStats(st *runStats) {
+	s.stats = st
+	s.observe(st)
+}
+
+// setEvents makes e report what the script does, for --json.
+func (s *Sed) setEvents(e *eventWriter) {
+	s.events = e
+	s.observe(e)
+}
+
+// context returns the ExecContext that registered commands, registered addresses and observers are handed, the
+// same one every time.
+func (s *Sed) context() *ExecContext {
+	s.ctx.s = s
+	return &s.ctx
+}
+
+// commandInfo returns the CommandInfo of cmd, made once for each command.
+func (s *Sed) commandInfo(cmd Cmd) CommandInfo {
+	info, ok := s.commandInfos[cmd]
+	if !ok {
+		pos := s.scriptPositions[cmd]
+		info = CommandInfo{String: cmd.String(), Source: cmd.source(), Line: pos.line, Column: pos.column}
+		if s.commandInfos == nil {
+			s.commandInfos = make(map[Cmd]CommandInfo)
+		}
+		s.commandInfos[cmd] = info
+	}
+	return info
+}
+
+// substitution is called by the s and m commands for each match they replace.
+func (s *Sed) substitution(start int, old, replacement []byte) {
+	for _, o := range s.observers {
+		o.Substitution(s.context(), start, old, replacement)
+	}
+}
+
+// printed is called by p and P for each line they print.
+func (s *Sed) printed(text []byte) {
+	for _, o := range s.observers {
+		o.printed(s.context(), text)
+	}
+}
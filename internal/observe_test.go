@@ -0,0 +1,125 @@
+// observe_test.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+package sed
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// traceObserver writes down every call it gets, one per line.
+type traceObserver struct {
+	trace strings.Builder
+}
+
+func (o *traceObserver) CycleStart(ctx *ExecContext) {
+	fmt.Fprintf(&o.trace, "start %d %s\n", ctx.LineNumber(), ctx.PatternSpace())
+}
+
+func (o *traceObserver) Command(ctx *ExecContext, cmd CommandInfo) {
+	fmt.Fprintf(&o.trace, "command %d:%d %s\n", cmd.Line, cmd.Column, cmd.Source)
+}
+
+func (o *traceObserver) Substitution(ctx *ExecContext, start int, old, replacement []byte) {
+	fmt.Fprintf(&o.trace, "substitution %d %s %s\n", start, old, replacement)
+}
+
+func (o *traceObserver) Write(ctx *ExecContext, line []byte) {
+	fmt.Fprintf(&o.trace, "write %s\n", line)
+}
+
+func (o *traceObserver) Branch(ctx *ExecContext, cmd CommandInfo, label string) {
+	fmt.Fprintf(&o.trace, "branch %s %q\n", cmd.Source, label)
+}
+
+func (o *traceObserver) CycleEnd(ctx *ExecContext) {
+	fmt.Fprintf(&o.trace, "end %d\n", ctx.LineNumber())
+}
+
+func TestObserver(t *testing.T) {
+	s, err := New([]byte("/a/s/a/A/g\nt done\n2d\n:done"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := new(traceObserver)
+	s.AddObserver(o)
+	counter := new(writeCounter)
+	s.AddObserver(counter)
+
+	var out bytes.Buffer
+	if err := s.Run(strings.NewReader("aba\nb\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	checkString(t, "output", "AbA\n", out.String())
+	checkString(t, "trace", `start 1 aba
+command 1:1 /a/s/a/A/g
+substitution 0 a A
+substitution 2 a A
+command 2:1 t done
+branch t done "done"
+write AbA
+end 1
+start 2 b
+command 2:1 t done
+command 3:1 2d
+end 2
+`, o.trace.String())
+	checkInt(t, counter.writes, 1, "writes")
+}
+
+// writeCounter is an Observer that only counts writes, NopObserver does the rest.
+type writeCounter struct {
+	NopObserver
+	writes int
+}
+
+func (c *writeCounter) Write(*ExecContext, []byte) {
+	c.writes++
+}
+
+// TestConcurrentScripts parses and runs scripts on several goroutines at once, which go test -race checks for shared
+// state. What one script sets, like #n, or the extensions it uses, mustn't show in the others.
+func TestConcurrentScripts(t *testing.T) {
+	tests := []struct {
+		script, expected string
+		extensions       int
+	}{
+		{"#n\np", "a\nb\n", 0},
+		{"s/a/b/", "b\nb\n", 0},
+		{"s/a+/x/\nh:r", "x\nb\n", 2},
+		{"2q", "a\nb\n", 0},
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		for _, test := range tests {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s, err := New([]byte(test.script))
+				if err != nil {
+					t.Errorf("%q: %v", test.script, err)
+					return
+				}
+				extensions := 0
+				for _, item := range s.scriptItems {
+					extensions += len(item.extensions)
+				}
+				var out bytes.Buffer
+				if err := s.Run(strings.NewReader("a\nb\n"), &out); err != nil {
+					t.Errorf("%q: %v", test.script, err)
+					return
+				}
+				checkString(t, test.script, test.expected, out.String())
+				checkInt(t, extensions, test.extensions, test.script+" extensions")
+			}()
+		}
+	}
+	wg.Wait()
+}
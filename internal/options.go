@@ -0,0 +1,296 @@
+// options.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+
+// Package sed implements the entire program, from this specific part, we parse the command line the way GNU's getopt_long does
+package sed
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// Err definitions for the command line
+var (
+	ErrInvalidOption         = errors.New("Invalid option")
+	ErrAmbiguousOption       = errors.New("Ambiguous option")
+	ErrOptionNeedsArgument   = errors.New("Option requires an argument")
+	ErrOptionTakesNoArgument = errors.New("Option doesn't allow an argument")
+)
+
+// longOptions maps the GNU long option names to the flags they are aliases of. Flags whose name is already
+// longer than one letter (e.g. --crlf) don't need an entry here.
+var longOptions = map[string]string{
+	"quiet":      "n",
+	"silent":     "n",
+	"expression": "e",
+	"file":       "f",
+	"in-place":   "i",
+	"help":       "h",
+	"unbuffered": "u",
+	"jobs":       "j",
+	"recursive":  "R",
+	"dry-run":    "diff",
+}
+
+// scriptFragment is one piece of the script, given either with -e, -f or --replace-table.
+type scriptFragment struct {
+	text     string
+	fromFile bool
+}
+
+// scriptFragments keeps every -e, -f and --replace-table option in the order it was given.
+var scriptFragments []scriptFragment
+
+// fragmentFlag is a flag.Value appending each of its values to scriptFragments, after command when it is set.
+type fragmentFlag struct {
+	fromFile bool
+	command  string
+}
+
+func (f *fragmentFlag) String() string { return "" }
+
+func (f *fragmentFlag) Set(value string) error {
+	scriptFragments = append(scriptFragments, scriptFragment{text: f.command + value, fromFile: f.fromFile})
+	return nil
+}
+
+// inPlaceFlag is the flag.Value behind -i, which takes an optional backup suffix that must be attached to it.
+type inPlaceFlag struct {
+	enabled bool
+	suffix  string
+}
+
+func (f *inPlaceFlag) String() string { return f.suffix }
+
+func (f *inPlaceFlag) IsBoolFlag() bool { return true }
+
+func (f *inPlaceFlag) Set(value string) error {
+	f.enabled = true
+	if value != "true" {
+		f.suffix = value
+	}
+	return nil
+}
+
+func (f *inPlaceFlag) optionalArgument() {}
+
+// globsFlag is a flag.Value collecting every glob it is given, for --include and --exclude.
+type globsFlag []string
+
+func (f *globsFlag) String() string { return strings.Join(*f, " ") }
+
+func (f *globsFlag) Set(value string) error {
+	if _, err := path.Match(value, ""); err != nil {
+		return fmt.Errorf("%w: %s", err, value)
+	}
+	*f = append(*f, value)
+	return nil
+}
+
+// optionalValue is implemented by flags whose argument is optional, such as -i[SUFFIX].
+// The argument is only taken when it is attached: -i.bak or --in-place=.bak
+type optionalValue interface {
+	flag.Value
+	optionalArgument()
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// lookupLongOption finds the flag of flags for a long option, aliases being the long names of its one-letter flags.
+// Like getopt_long, any unambiguous prefix of a long option is accepted.
+func lookupLongOption(flags *flag.FlagSet, aliases map[string]string, name string) (*flag.Flag, error) {
+	if alias, ok := aliases[name]; ok {
+		return flags.Lookup(alias), nil
+	}
+	if f := flags.Lookup(name); f != nil && len(name) > 1 {
+		return f, nil
+	}
+	var found *flag.Flag
+	candidates := make(map[string]bool)
+	try := func(long, flagName string) {
+		if strings.HasPrefix(long, name) {
+			found = flags.Lookup(flagName)
+			candidates[flagName] = true
+		}
+	}
+	for long, alias := range aliases {
+		try(long, alias)
+	}
+	flags.VisitAll(func(f *flag.Flag) {
+		if len(f.Name) > 1 {
+			try(f.Name, f.Name)
+		}
+	})
+	switch {
+	case name == "" || len(candidates) == 0:
+		return nil, fmt.Errorf("%w '--%s'", ErrInvalidOption, name)
+	case len(candidates) > 1:
+		return nil, fmt.Errorf("%w '--%s'", ErrAmbiguousOption, name)
+	}
+	return found, nil
+}
+
+// parseArgs parses args (without the program name) like getopt_long does: short options can be combined
+// (-ne p), their arguments attached or separate (-e p, -ep), long options take --name=value or --name value,
+// options may come after operands, and "--" ends the options. It returns the operands in order.
+// As with getopt_long, setting POSIXLY_CORRECT makes the first operand end the options.
+func parseArgs(args []string) ([]string, error) {
+	return parseFlagSet(commandLine, longOptions, args)
+}
+
+// parseFlagSet is parseArgs for any set of flags, aliases being the long names of its one-letter flags.
+// The subcommands parse their own flags with it, so they take options the same way sed does.
+func parseFlagSet(flags *flag.FlagSet, aliases map[string]string, args []string) ([]string, error) {
+	var operands []string
+	posixlyCorrect := os.Getenv("POSIXLY_CORRECT") != ""
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case posixlyCorrect && len(operands) > 0:
+			operands = append(operands, arg)
+		case arg == "--":
+			return append(operands, args[i+1:]...), nil
+		case strings.HasPrefix(arg, "--"):
+			name, value, hasValue := strings.Cut(arg[2:], "=")
+			f, err := lookupLongOption(flags, aliases, name)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := f.Value.(optionalValue); !ok && isBoolFlag(f) && hasValue {
+				return nil, fmt.Errorf("%w '--%s'", ErrOptionTakesNoArgument, name)
+			}
+			if !hasValue {
+				value = "true"
+				if !isBoolFlag(f) {
+					if i+1 >= len(args) {
+						return nil, fmt.Errorf("%w '--%s'", ErrOptionNeedsArgument, name)
+					}
+					i++
+					value = args[i]
+				}
+			}
+			if err := f.Value.Set(value); err != nil {
+				return nil, err
+			}
+		case len(arg) > 1 && arg[0] == '-':
+			for j := 1; j < len(arg); j++ {
+				f := flags.Lookup(arg[j : j+1])
+				if f == nil {
+					return nil, fmt.Errorf("%w -- '%c'", ErrInvalidOption, arg[j])
+				}
+				rest := arg[j+1:]
+				if _, ok := f.Value.(optionalValue); ok && rest != "" {
+					if err := f.Value.Set(rest); err != nil {
+						return nil, err
+					}
+					break
+				}
+				if isBoolFlag(f) {
+					if err := f.Value.Set("true"); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				if rest == "" {
+					if i+1 >= len(args) {
+						return nil, fmt.Errorf("%w -- '%c'", ErrOptionNeedsArgument, arg[j])
+					}
+					i++
+					rest = args[i]
+				}
+				if err := f.Value.Set(rest); err != nil {
+					return nil, err
+				}
+				break
+			}
+		default:
+			operands = append(operands, arg)
+		}
+	}
+	return operands, nil
+}
+
+// subcommandFlags returns a FlagSet for the subcommand name, with a -h (--help) flag that prints its usage: the
+// synopsis, the description and the options.
+func subcommandFlags(name, synopsis, description string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Bool("h", false, "Show this help and exit")
+	flags.SetOutput(os.Stdout)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s\n\n%s\n\nOptions:\n", strings.TrimSpace("gosed "+name+" "+synopsis), description)
+		flags.VisitAll(func(f *flag.Flag) {
+			dash := "-"
+			if len(f.Name) > 1 {
+				dash = "--"
+			}
+			argName, usage := flag.UnquoteUsage(f)
+			if argName != "" {
+				argName = " " + argName
+			}
+			if f.DefValue != "" && f.DefValue != "false" {
+				usage += fmt.Sprintf(" (default %s)", f.DefValue)
+			}
+			fmt.Fprintf(flags.Output(), "  %s%s%s\n\t%s\n", dash, f.Name, argName, usage)
+		})
+	}
+	return flags
+}
+
+// parseSubcommandArgs is parseFlagSet for the flags of subcommandFlags, which also takes --help. It prints the usage
+// for -h and errors to stderr, after which done is true and the subcommand returns status.
+func parseSubcommandArgs(flags *flag.FlagSet, aliases map[string]string, args []string) (operands []string, status int, done bool) {
+	long := map[string]string{"help": "h"}
+	for name, alias := range aliases {
+		long[name] = alias
+	}
+	operands, err := parseFlagSet(flags, long, args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error, %s\n", err.Error())
+		return nil, 2, true
+	}
+	if flags.Lookup("h").Value.String() == "true" {
+		flags.Usage()
+		return nil, 0, true
+	}
+	return operands, 0, false
+}
+
+// buildScript concatenates the -e and -f fragments in the order they were given, one per line.
+// A -f of "-" reads the script from the standard input.
+func buildScript(fragments []scriptFragment) ([]byte, error) {
+	var buf bytes.Buffer
+	for i, fragment := range fragments {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+		if !fragment.fromFile {
+			buf.WriteString(fragment.text)
+			continue
+		}
+		var sb []byte
+		var err error
+		if fragment.text == "-" {
+			sb, err = io.ReadAll(os.Stdin)
+		} else {
+			sb, err = os.ReadFile(fragment.text)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error reading script file %s: %w", fragment.text, err)
+		}
+		buf.Write(bytes.TrimSuffix(sb, newLine))
+	}
+	return buf.Bytes(), nil
+}
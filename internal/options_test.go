@@ -0,0 +1,65 @@
+// options_test.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+package sed
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	defer func() {
+		*quiet = false
+		*editInplace = inPlaceFlag{}
+		scriptFragments = nil
+	}()
+
+	operands, err := parseArgs([]string{"-ne", "p", "in1", "--expression=2d", "-i.bak", "--", "-in2"})
+	if err != nil {
+		t.Fatalf("Got an error we didn't expect: %v", err)
+	}
+	if !*quiet {
+		t.Error("Combined -n wasn't set")
+	}
+	if !editInplace.enabled || editInplace.suffix != ".bak" {
+		t.Errorf("Bad -i: %+v", *editInplace)
+	}
+	checkString(t, "operands", "in1 -in2", strings.Join(operands, " "))
+	script, err := buildScript(scriptFragments)
+	if err != nil {
+		t.Fatalf("Got an error we didn't expect: %v", err)
+	}
+	checkString(t, "fragments must be joined in order", "p\n2d", string(script))
+
+	if _, err = parseArgs([]string{"-e"}); !errors.Is(err, ErrOptionNeedsArgument) {
+		t.Errorf("Expected %v, got %v", ErrOptionNeedsArgument, err)
+	}
+	if _, err = parseArgs([]string{"-y"}); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("Expected %v, got %v", ErrInvalidOption, err)
+	}
+	if _, err = parseArgs([]string{"--qu"}); err != nil {
+		t.Errorf("Got an error for an unambiguous prefix: %v", err)
+	}
+}
+
+func TestSubcommandHelp(t *testing.T) {
+	for _, arg := range []string{"-h", "--help", "--he"} {
+		flags := subcommandFlags("explain", "[-f FILE] [script...]", "Describe a script.")
+		flags.String("f", "", "Read the script from `FILE`")
+		var usage strings.Builder
+		flags.SetOutput(&usage)
+		if _, status, done := parseSubcommandArgs(flags, nil, []string{"p", arg}); !done || status != 0 {
+			t.Errorf("%s: expected to be done with status 0, got %v and %d", arg, done, status)
+		}
+		checkString(t, arg, "Usage: gosed explain [-f FILE] [script...]\n\nDescribe a script.\n\nOptions:\n  -f FILE\n\tRead the script from FILE\n  -h\n\tShow this help and exit\n", usage.String())
+	}
+	flags := subcommandFlags("lsp", "", "Talk to an editor.")
+	if operands, _, done := parseSubcommandArgs(flags, nil, []string{"x"}); done || len(operands) != 1 {
+		t.Errorf("Expected the operand x, got %v", operands)
+	}
+}
@@ -0,0 +1,300 @@
+// parallel.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+
+// Package sed implements the entire program, from this specific part, we implement the parallel processing: -j, which edits files in place on several goroutines at once, and the splitting of an input between goroutines for scripts that treat every line on its own
+package sed
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync/atomic"
+)
+
+// editJob is the editing of one file by a worker of editInParallel.
+type editJob struct {
+	temp     string // what editToTemp wrote, empty when it failed or the job was skipped
+	err      error
+	quit     bool
+	exitCode int
+	stats    *runStats    // what the worker counted, for the stats of the whole run
+	events   bytes.Buffer // the --json events of the file
+	done     chan struct{}
+}
+
+// fork returns a Sed running the same script as s with an execution state of its own, for another goroutine.
+// The commands themselves are shared, running them changes nothing but the Sed they run on.
+func (s *Sed) fork() *Sed {
+	w := new(Sed)
+	w.Init()
+	w.program = s.program
+	w.scriptItems = s.scriptItems
+	w.scriptPositions = s.scriptPositions
+	w.unbuffered = s.unbuffered
+	w.quiet, w.posix, w.crlf = s.quiet, s.posix, s.crlf
+	if s.stats != nil {
+		w.setStats(newRunStats(s))
+	}
+	return w
+}
+
+// forkEditor is fork for a worker of editInParallel, which has its --json events written to events, to be
+// copied to the real output in the order of the files.
+func (s *Sed) forkEditor(events io.Writer) *Sed {
+	w := s.fork()
+	if s.events != nil {
+		w.setEvents(newEventWriter(events))
+	}
+	return w
+}
+
+// editInParallel edits the files names in place with workers goroutines, 0 meaning one per CPU. Each file is edited
+// into a temporary file by a worker, and these are put in place one after the other in the order of names. Errors,
+// q and the exit code are then just what they would be editing the files one at a time: the files after the one a
+// command quits in or an error happens on are left alone. Only the hold space is different, every file starts with
+// an empty one instead of the one the previous file left behind. Errors are printed to stderr as they are returned.
+// commit puts an edit in place, commitEdit or the diffEdit of --diff.
+func (s *Sed) editInParallel(names []string, workers int, commit func(name, temp string) error) (int, error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	jobs := make([]editJob, len(names))
+	for i := range jobs {
+		jobs[i].done = make(chan struct{})
+	}
+	var next atomic.Int64
+	var stop atomic.Bool
+	// Workers can't get further ahead of the file being put in place than this, it bounds the temporary files
+	ahead := make(chan struct{}, 2*workers)
+	for n := 0; n < workers; n++ {
+		go func() {
+			for {
+				ahead <- struct{}{}
+				i := int(next.Add(1) - 1)
+				if i >= len(jobs) {
+					<-ahead
+					return
+				}
+				job := &jobs[i]
+				if !stop.Load() {
+					w := s.forkEditor(&job.events)
+					w.beginFile(names[i])
+					job.temp, job.err = w.editToTemp(names[i])
+					job.quit, job.exitCode = w.quit, w.exitCode
+					if job.err == nil {
+						w.endFile()
+						job.stats = w.stats
+					}
+				}
+				close(job.done)
+			}
+		}()
+	}
+
+	exitCode := 0
+	var failed error
+	for i := range jobs {
+		job := &jobs[i]
+		<-job.done
+		<-ahead
+		if stop.Load() {
+			// Whatever was edited past the end is thrown away
+			if job.temp != "" {
+				os.Remove(job.temp)
+			}
+			continue
+		}
+		err := job.err
+		if err == nil {
+			if err = s.collect(job); err != nil {
+				os.Remove(job.temp)
+			} else {
+				err = commit(names[i], job.temp)
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			failed = err
+			stop.Store(true)
+			continue
+		}
+		if job.quit {
+			exitCode = job.exitCode
+			stop.Store(true)
+		}
+	}
+	return exitCode, failed
+}
+
+// collect adds the stats and --json events of a worker's job to those of s.
+func (s *Sed) collect(job *editJob) error {
+	if job.stats != nil {
+		s.stats.merge(job.stats)
+	}
+	if s.events != nil {
+		if _, err := s.events.w.Write(job.events.Bytes()); err != nil {
+			return fmt.Errorf("Error writing output: %w", err)
+		}
+		if err := s.events.flush(); err != nil {
+			return fmt.Errorf("Error writing output: %w", err)
+		}
+	}
+	return nil
+}
+
+// chunkSize is about how much of the input runChunked hands to a goroutine at once, chunks end with a line.
+var chunkSize = 1 << 20
+
+// inputChunk is a piece of the input made of whole lines, with the output of the script over it.
+type inputChunk struct {
+	in      []byte
+	out     bytes.Buffer
+	line    int   // the number of the line before the first one of the chunk
+	pending bool  // the chunk ends with a line without line ending, whose output held its own back
+	readErr error // reading the input failed right after the chunk
+	err     error
+	done    chan struct{}
+}
+
+// stateless reports whether the script treats every line on its own, so that the output for a line depends on
+// nothing but the line. That rules out the hold space, commands reading more lines (n, N and D), whatever needs
+// the line number (line addresses, ranges, $ and =) and q, which decides the fate of every line after it.
+func (s *Sed) stateless() bool {
+	for _, in := range s.program {
+		if in.addr != nil && in.addr.addressType != addressRegEx {
+			return false
+		}
+		switch c := in.cmd.(type) {
+		case *ACmd, *BCmd, *BlockCmd, *BlockEndCmd, *LabelCmd, *CCmd, *ICmd, *MCmd, *PCmd, *RCmd, *SCmd, *YCmd:
+		case *DCmd:
+			if c.upToFirstNewLine {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// runChunked is run for stateless scripts: the input is cut into chunks of lines, workers goroutines run the
+// script over them at the same time and their output is written in the order of the input. Reading, running
+// and writing overlap, and no more than a few chunks per worker are ever in memory.
+func (s *Sed) runChunked(workers int) error {
+	if editInplace.enabled {
+		s.lineNumber = 0
+	}
+	s.skipBOM()
+
+	chunks := make([]inputChunk, 2*workers+1)
+	free := make(chan *inputChunk, len(chunks))
+	for i := range chunks {
+		free <- &chunks[i]
+	}
+	todo := make(chan *inputChunk, len(chunks))    // for the workers
+	ordered := make(chan *inputChunk, len(chunks)) // for the output, in the order of the input
+	stop := make(chan struct{})
+
+	go func() {
+		defer close(ordered)
+		defer close(todo)
+		line := s.lineNumber
+		for {
+			var c *inputChunk
+			select {
+			case c = <-free:
+			case <-stop:
+				return
+			}
+			c.done = make(chan struct{})
+			c.line = line
+			var err error
+			c.in, err = readChunk(s.input, c.in[:0])
+			c.readErr = nil
+			if err != nil && err != io.EOF {
+				c.readErr = fmt.Errorf("Error reading input: %w", err)
+			}
+			if len(c.in) == 0 && c.readErr == nil {
+				return
+			}
+			line += bytes.Count(c.in, newLine)
+			todo <- c
+			ordered <- c
+			if err != nil {
+				return
+			}
+		}
+	}()
+	for n := 0; n < workers; n++ {
+		go func() {
+			w := s.fork()
+			reader := bufio.NewReaderSize(nil, 64*1024)
+			for c := range todo {
+				reader.Reset(bytes.NewReader(c.in))
+				w.input = reader
+				c.out.Reset()
+				w.output.Reset(&c.out)
+				w.lineNumber = c.line
+				w.pendingNewline = false
+				c.err = w.cycles()
+				c.pending = w.pendingNewline
+				close(c.done)
+			}
+		}()
+	}
+
+	for c := range ordered {
+		<-c.done
+		if c.out.Len() > 0 {
+			s.write(c.out.Bytes())
+		}
+		if c.pending {
+			s.pendingNewline = true
+		}
+		s.lineNumber = c.line + bytes.Count(c.in, newLine)
+		err := c.err
+		if err == nil {
+			err = c.readErr
+		}
+		if err != nil {
+			// Let the goroutines finish with what they have before the input goes away
+			close(stop)
+			for c := range ordered {
+				<-c.done
+			}
+			s.flush()
+			return err
+		}
+		free <- c
+	}
+	return s.flush()
+}
+
+// readChunk appends about chunkSize bytes of r to dst, then the rest of the line it stopped in. The error is
+// io.EOF once r runs out, whatever was read before it is returned with it.
+func readChunk(r *bufio.Reader, dst []byte) ([]byte, error) {
+	if cap(dst) < chunkSize {
+		dst = make([]byte, 0, chunkSize+4096)
+	}
+	n, err := io.ReadFull(r, dst[:chunkSize])
+	dst = dst[:n]
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	for err == nil && dst[len(dst)-1] != '\n' {
+		var rest []byte
+		rest, err = r.ReadSlice('\n')
+		dst = append(dst, rest...)
+		if err == bufio.ErrBufferFull {
+			err = nil
+		}
+	}
+	return dst, err
+}
@@ -0,0 +1,131 @@
+// parallel_test.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+package sed
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// editFiles writes contents to files in a new directory, edits them in place with script on workers goroutines
+// and returns what the files hold afterwards, with the exit code.
+func editFiles(t *testing.T, script string, contents []string, workers int) ([]string, int) {
+	saved := *editInplace
+	*editInplace = inPlaceFlag{enabled: true}
+	defer func() { *editInplace = saved }()
+
+	dir := t.TempDir()
+	names := make([]string, len(contents))
+	for i, content := range contents {
+		names[i] = filepath.Join(dir, fmt.Sprintf("file%03d", i))
+		if err := os.WriteFile(names[i], []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := new(Sed)
+	s.Init()
+	if err := s.parseScript([]byte(script)); err != nil {
+		t.Fatalf("Got an error parsing %q: %v", script, err)
+	}
+	exitCode, err := s.editInParallel(names, workers, commitEdit)
+	if err != nil {
+		t.Fatalf("%q: %v", script, err)
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if len(leftovers) > 0 {
+		t.Errorf("%q: temporary files left behind: %v", script, leftovers)
+	}
+	edited := make([]string, len(names))
+	for i, name := range names {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		edited[i] = string(b)
+	}
+	return edited, exitCode
+}
+
+func TestEditInParallel(t *testing.T) {
+	contents := make([]string, 50)
+	for i := range contents {
+		contents[i] = strings.Repeat(fmt.Sprintf("line %d of file %d\n", i%7, i), i%5+1)
+	}
+	for _, script := range []string{"s/line/LINE/", "$!d", "1h;1!G", "/ 3 /d;s/file/& &/2"} {
+		edited, _ := editFiles(t, script, contents, 4)
+		for i := range contents {
+			checkString(t, fmt.Sprintf("%q on file %d", script, i), runSed(t, script, contents[i]), edited[i])
+		}
+	}
+
+	// q in the 20th file leaves the ones after it alone, however far ahead the workers got
+	edited, exitCode := editFiles(t, "/of file 20$/q5", contents, 8)
+	checkInt(t, exitCode, 5, "exit code of q5")
+	for i := range contents {
+		expected := contents[i]
+		if i == 20 {
+			expected = strings.SplitAfter(contents[i], "\n")[0]
+		}
+		checkString(t, fmt.Sprintf("q on file %d", i), expected, edited[i])
+	}
+}
+
+func TestStateless(t *testing.T) {
+	for script, expected := range map[string]bool{
+		"s/foo/bar/g":               true,
+		"/^#/d;s/a/b/;y/xy/yx/":     true,
+		"/x/!{p;s/a/&&/2;}":         true,
+		":a;s/aa/a/;ta":             true,
+		"/a/i\\\nbefore":            true,
+		"/a/c\\\nchanged":           true,
+		"P;d":                       true,
+		"h":                         false,
+		"G":                         false,
+		"N;P;D":                     false,
+		"$!d":                       false,
+		"1d":                        false,
+		"2,4s/a/b/":                 false,
+		"=":                         false,
+		"/stop/q":                   false,
+		"n;d":                       false,
+		"s/a/b/;/x/{x;p;x;}":        false,
+		"/a/!D":                     false,
+		"# nothing but a comment\n": true,
+	} {
+		s := new(Sed)
+		s.Init()
+		if err := s.parseScript([]byte(script)); err != nil {
+			t.Fatalf("Got an error parsing %q: %v", script, err)
+		}
+		if s.stateless() != expected {
+			t.Errorf("%q: expected stateless to be %v", script, expected)
+		}
+	}
+}
+
+// TestRunChunked runs stateless scripts over inputs cut into many small chunks, the output must be the same.
+func TestRunChunked(t *testing.T) {
+	saved := chunkSize
+	chunkSize = 16
+	defer func() { chunkSize = saved }()
+
+	var lines []string
+	for i := 0; i < 300; i++ {
+		lines = append(lines, strings.Repeat(fmt.Sprintf("foo%d bar ", i%11), i%4))
+	}
+	whole := strings.Join(lines, "\n")
+	for _, input := range []string{"", "one line", "one line\n", whole, whole + "\n", "\n\n\nfoo\n", string(utf8BOM) + whole} {
+		for _, script := range []string{"s/foo/X/g", "/bar/!d", "s/foo(1)/<&>/2;ta;p;:a", "/foo3/a\\\nappended", "/^$/c\\\nempty"} {
+			sequential := runSed(t, script, input)
+			chunked := runSedWith(t, script, input, func(s *Sed) { s.chunkWorkers = 4 })
+			checkString(t, fmt.Sprintf("%q on %d bytes", script, len(input)), sequential, chunked)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// plugin.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+
+// Package sed implements the entire program, from this specific part, we let Go code register commands and addresses of its own, which scripts then use like any other
+package sed
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Plugin errors
+var (
+	ErrBadCommandName        = errors.New("A registered command needs a name of letters, digits and _ that isn't a command letter of sed")
+	ErrCommandRegistered     = errors.New("A command with this name is already registered")
+	ErrMissingCommandExec    = errors.New("A registered command needs an Exec function")
+	ErrBadCommandAddresses   = errors.New("The MaxAddresses of a registered command is 0 or 2 for any address, 1 for one, or NoAddresses")
+	ErrUnexpectedCommandText = errors.New("This command doesn't take an argument")
+	ErrBadAddressName        = errors.New("A registered address needs a name of letters, digits and _, and a parser")
+	ErrAddressRegistered     = errors.New("An address with this name is already registered")
+)
+
+// NoAddresses is the MaxAddresses of a registered command that takes no address, like : or }.
+const NoAddresses = -1
+
+// builtinCommands are the command letters of sed, which can't be registered.
+const builtinCommands = "{}:=abcdDgGhHilmnNpPqrstwxy"
+
+// CommandDef defines a command registered with RegisterCommand:
+//
+//	sed.RegisterCommand(sed.CommandDef{
+//		Name: "redact",
+//		Exec: func(ctx *sed.ExecContext, _ any) error {
+//			ctx.SetPatternSpace(secret.ReplaceAll(ctx.PatternSpace(), []byte("***")))
+//			return nil
+//		},
+//	})
+//
+// after which /token/redact works in any script. A command without Parse takes no argument and can be followed by
+// another command on the same line, one with Parse gets the rest of its line as its argument, like r does.
+type CommandDef struct {
+	// Name is the word the command is invoked by, like "redact". It takes precedence over a command of sed it
+	// begins with, "redact" is never r reading the file "edact".
+	Name string
+	// MaxAddresses is the most addresses the command takes. The zero value lets it take any, a line or a range like
+	// most commands, 2 says the same, 1 limits it to a single line and NoAddresses forbids an address.
+	MaxAddresses int
+	// Parse, if not nil, turns the argument of the command into the value handed to Exec when the script is parsed.
+	Parse func(arg string) (any, error)
+	// Exec runs the command on the current cycle with the value Parse returned. With -j it runs for several files at
+	// once, so it must not keep state outside ctx without a lock.
+	Exec func(ctx *ExecContext, arg any) error
+}
+
+// commandDefs are the registered commands by name.
+var commandDefs = make(map[string]*CommandDef)
+
+// RegisterCommand adds the command def to the ones every script can use. It is meant to be called before the script
+// is parsed, from an init function or at the start of main, and isn't safe to call concurrently.
+func RegisterCommand(def CommandDef) error {
+	if !isCommandName(def.Name) {
+		return fmt.Errorf("%w: %q", ErrBadCommandName, def.Name)
+	}
+	if _, ok := commandDefs[def.Name]; ok {
+		return fmt.Errorf("%w: %s", ErrCommandRegistered, def.Name)
+	}
+	if def.Exec == nil {
+		return fmt.Errorf("%w: %s", ErrMissingCommandExec, def.Name)
+	}
+	if def.MaxAddresses < NoAddresses || def.MaxAddresses > 2 {
+		return fmt.Errorf("%w: %s", ErrBadCommandAddresses, def.Name)
+	}
+	commandDefs[def.Name] = &def
+	return nil
+}
+
+// isCommandName reports whether name can be the name of a registered command.
+func isCommandName(name string) bool {
+	if name == "" || len(name) == 1 && strings.Contains(builtinCommands, name) {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isRegisterName(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// registeredCommand returns the registered command line starts with and the length of its name, nil when it
+// doesn't start with one.
+func registeredCommand(line []byte) (*CommandDef, int) {
+	n := 0
+	for n < len(line) && isRegisterName(line[n]) {
+		n++
+	}
+	def := commandDefs[string(line[:n])]
+	if def == nil {
+		return nil, 0
+	}
+	return def, n
+}
+
+// addressDefs are the parsers of the registered addresses by name.
+var addressDefs = make(map[string]func(arg string) (Address, error))
+
+// RegisterAddress lets scripts select lines with @name, or @name{argument}, for the Address parse returns for the
+// argument ("" without one) when the script is parsed:
+//
+//	sed.RegisterAddress("changed", func(arg string) (sed.Address, error) {
+//		lines, err := changedLines(arg)
+//		return sed.AddressFunc(func(ctx *sed.ExecContext) bool { return lines[ctx.LineNumber()] }), err
+//	})
+//
+// after which @changed{main.go}s/foo/bar/ only edits the lines git sees changed. Like the other addresses, they take
+// a '!' and make ranges, @begin,@end or 5,@changed{}. The braces of an argument right after the name belong to the
+// address, a block needs a blank before it: @changed {. Like RegisterCommand, it is meant to be called before the
+// script is parsed and isn't safe to call concurrently.
+func RegisterAddress(name string, parse func(arg string) (Address, error)) error {
+	if name == "" || parse == nil || !isCommandName(name) {
+		return fmt.Errorf("%w: %q", ErrBadAddressName, name)
+	}
+	if _, ok := addressDefs[name]; ok {
+		return fmt.Errorf("%w: %s", ErrAddressRegistered, name)
+	}
+	addressDefs[name] = parse
+	return nil
+}
+
+// ExecContext is what the Exec function of a registered command, or the Match method of an Address, sees of the
+// cycle it runs in.
+type ExecContext struct {
+	s       *Sed
+	command string
+	delete  bool
+}
+
+// PatternSpace returns the pattern space. The slice belongs to sed: it may be changed in place, but not kept after
+// Exec returns, the next line is read into it.
+func (ctx *ExecContext) PatternSpace() []byte {
+	return ctx.s.patternSpace
+}
+
+// SetPatternSpace replaces the pattern space with a copy of b, which stays the caller's to reuse. b may be the
+// pattern space itself, or a part of it.
+func (ctx *ExecContext) SetPatternSpace(b []byte) {
+	ctx.s.patternSpace = append(ctx.s.patternSpace[:0], b...)
+}
+
+// HoldSpace returns the hold space, or the named hold register of h:NAME when name is given. Like with PatternSpace,
+// the slice belongs to sed and must not be kept after Exec returns.
+func (ctx *ExecContext) HoldSpace(name ...string) []byte {
+	return ctx.s.hold(registerName(name))
+}
+
+// SetHoldSpace replaces the hold space, or the named hold register when name is given, with a copy of b, which
+// stays the caller's to reuse.
+func (ctx *ExecContext) SetHoldSpace(b []byte, name ...string) {
+	register := registerName(name)
+	ctx.s.setHold(register, append(ctx.s.hold(register)[:0], b...))
+}
+
+// registerName returns the register the optional name of a hold space method of ExecContext stands for.
+func registerName(name []string) string {
+	if len(name) == 0 {
+		return ""
+	}
+	return name[0]
+}
+
+// LineNumber returns the number of the current input line.
+func (ctx *ExecContext) LineNumber() int {
+	return ctx.s.lineNumber
+}
+
+// LastLine reports whether the current line is the last one of the input, the lines $ matches.
+func (ctx *ExecContext) LastLine() bool {
+	return ctx.s.atEOF()
+}
+
+// WriteLine writes line and a line ending to the output right away, like p does.
+func (ctx *ExecContext) WriteLine(line []byte) {
+	ctx.s.writeLine(line)
+}
+
+// Append queues text to be written at the end of the cycle, like a does.
+func (ctx *ExecContext) Append(text []byte) {
+	ctx.s.appendQueue = append(ctx.s.appendQueue, copyByteSlice(text))
+}
+
+// Delete ends the cycle once Exec returns, without printing the pattern space, like d does.
+func (ctx *ExecContext) Delete() {
+	ctx.delete = true
+}
+
+// Command returns the command being run as it is written in the script.
+func (ctx *ExecContext) Command() string {
+	return ctx.command
+}
+
+// PluginCmd is a command registered with RegisterCommand, as used in a script.
+type PluginCmd struct {
+	addr *address
+	def  *CommandDef
+	text string // the argument as written, "" when the command takes none
+	arg  any    // what Parse made of text
+}
+
+// match checks if the given line matches the address criteria of the PluginCmd.
+func (c *PluginCmd) match(line []byte, lineNumber int) bool {
+	return c.addr.match(line, lineNumber)
+}
+
+// getAddress returns the address of the PluginCmd, nil when it applies to every line.
+func (c *PluginCmd) getAddress() *address {
+	return c.addr
+}
+
+// String returns a string representation of the PluginCmd, including its address and argument.
+func (c *PluginCmd) String() string {
+	if c.addr != nil {
+		return fmt.Sprintf("{%s command arg:%q addr:%s}", c.def.Name, c.text, c.addr.String())
+	}
+	return fmt.Sprintf("{%s command arg:%q}", c.def.Name, c.text)
+}
+
+// source returns the PluginCmd in canonical sed syntax.
+func (c *PluginCmd) source() string {
+	if c.def.Parse != nil && c.text != "" {
+		return c.addr.source() + c.def.Name + " " + c.text
+	}
+	return c.addr.source() + c.def.Name
+}
+
+// processLine runs the Exec function of the command, ending the cycle when it asked to delete the pattern space.
+func (c *PluginCmd) processLine(s *Sed) (bool, error) {
+	ctx := s.context()
+	ctx.command, ctx.delete = c.source(), false
+	if err := c.def.Exec(ctx, c.arg); err != nil {
+		return false, err
+	}
+	return ctx.delete, nil
+}
+
+// NewPluginCmd creates a new PluginCmd of the registered command def from line, the command with its argument.
+func NewPluginCmd(s *Sed, def *CommandDef, line []byte, addr *address) (*PluginCmd, error) {
+	if err := s.checkPOSIX(def.Name + " command"); err != nil {
+		return nil, err
+	}
+	switch {
+	case def.MaxAddresses == NoAddresses && addr != nil:
+		return nil, fmt.Errorf("%w: %s", ErrNoAddressAllowed, def.Name)
+	case def.MaxAddresses == 1 && addr.isRange():
+		return nil, fmt.Errorf("%w: %s", ErrNoSupportForTwoAddress, def.Name)
+	}
+	cmd := &PluginCmd{addr: addr, def: def, text: string(bytes.TrimSpace(line[len(def.Name):]))}
+	if def.Parse == nil {
+		if cmd.text != "" {
+			return nil, fmt.Errorf("%w: %s", ErrUnexpectedCommandText, def.Name)
+		}
+		return cmd, nil
+	}
+	var err error
+	if cmd.arg, err = def.Parse(cmd.text); err != nil {
+		return nil, fmt.Errorf("%s: %w", def.Name, err)
+	}
+	return cmd, nil
+}
@@ -0,0 +1,200 @@
+// plugin_test.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+package sed
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRegisterCommand(t *testing.T) {
+	secret := regexp.MustCompile(`token=\S+`)
+	defs := []CommandDef{
+		{
+			Name: "redact",
+			Exec: func(ctx *ExecContext, _ any) error {
+				ctx.SetPatternSpace(secret.ReplaceAll(ctx.PatternSpace(), []byte("token=***")))
+				return nil
+			},
+		},
+		{
+			Name:         "repeat",
+			MaxAddresses: 1,
+			Parse: func(arg string) (any, error) {
+				return strconv.Atoi(arg)
+			},
+			Exec: func(ctx *ExecContext, arg any) error {
+				for i := 0; i < arg.(int); i++ {
+					ctx.WriteLine(ctx.PatternSpace())
+				}
+				return nil
+			},
+		},
+		{
+			Name:         "stash",
+			MaxAddresses: NoAddresses,
+			Exec: func(ctx *ExecContext, _ any) error {
+				ctx.SetHoldSpace(append(append(ctx.HoldSpace("seen"), ctx.PatternSpace()...), ' '), "seen")
+				if ctx.LastLine() {
+					ctx.Append(bytes.TrimSpace(ctx.HoldSpace("seen")))
+				}
+				ctx.Delete()
+				return nil
+			},
+		},
+	}
+	for _, def := range defs {
+		if err := RegisterCommand(def); err != nil {
+			t.Fatal(err)
+		}
+		defer delete(commandDefs, def.Name)
+	}
+
+	checkString(t, "redact", "a token=*** b\nRest\n", runSed(t, "/token/redact;s/^r/R/", "a token=abc b\nrest\n"))
+	checkString(t, "redact isn't r", "x\n", runSed(t, "redact", "x\n"))
+	checkString(t, "repeat", "a\nb\nb\nb\nc\n", runSed(t, "2repeat 2", "a\nb\nc\n"))
+	checkString(t, "stash", "a b c\n", runSed(t, "stash", "a\nb\nc\n"))
+
+	c, err := NewCmd(new(Sed), []byte("/x/repeat  3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkString(t, "canonical form", "/x/repeat 3", c.source())
+
+	for _, test := range []struct {
+		script string
+		err    error
+	}{
+		{"1,2repeat 2", ErrNoSupportForTwoAddress},
+		{"1stash", ErrNoAddressAllowed},
+		{"redact now", ErrUnexpectedCommandText},
+		{"repeat x", strconv.ErrSyntax},
+	} {
+		_, err := NewCmd(new(Sed), []byte(test.script))
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.script, test.err, err)
+		}
+	}
+
+	for _, def := range []CommandDef{
+		{Name: "p", Exec: defs[0].Exec},
+		{Name: "re-dact", Exec: defs[0].Exec},
+		{Name: "redact", Exec: defs[0].Exec},
+		{Name: "norun"},
+		{Name: "far", MaxAddresses: 3, Exec: defs[0].Exec},
+		{Name: "near", MaxAddresses: -2, Exec: defs[0].Exec},
+	} {
+		if err := RegisterCommand(def); err == nil {
+			delete(commandDefs, def.Name)
+			t.Errorf("%s: registered a bad command", def.Name)
+		}
+	}
+
+	posix := new(Sed)
+	posix.posix = true
+	if _, err := NewCmd(posix, []byte("redact")); !errors.Is(err, ErrPOSIXExtension) {
+		t.Errorf("redact: expected %v, got %v", ErrPOSIXExtension, err)
+	}
+}
+
+func TestRegisteredCommandBuffers(t *testing.T) {
+	// The commands keep reusing their buffers, sed mustn't read lines into them or see them change
+	fixed := make([]byte, 0, 64)
+	fixed = append(fixed, "REDACTED"...)
+	saved := make([]byte, 0, 64)
+	for _, def := range []CommandDef{
+		{Name: "blank", Exec: func(ctx *ExecContext, _ any) error {
+			ctx.SetPatternSpace(fixed)
+			return nil
+		}},
+		{Name: "save", Exec: func(ctx *ExecContext, _ any) error {
+			saved = append(saved[:0], ctx.PatternSpace()...)
+			ctx.SetHoldSpace(saved, "saved")
+			return nil
+		}},
+		{Name: "clobber", Exec: func(ctx *ExecContext, _ any) error {
+			saved = append(saved[:0], "clobbered"...)
+			return nil
+		}},
+	} {
+		if err := RegisterCommand(def); err != nil {
+			t.Fatal(err)
+		}
+		defer delete(commandDefs, def.Name)
+	}
+
+	checkString(t, "blank", "REDACTED\nhello\nREDACTED\n", runSed(t, "/secret/blank", "secret1\nhello\nsecret2\n"))
+	checkString(t, "the buffer of blank", "REDACTED", string(fixed))
+	checkString(t, "save", "a\nb\n", runSed(t, "save;clobber;g:saved", "a\nb\n"))
+	checkString(t, "the buffer of save", "clobbered", string(saved))
+}
+
+func TestRegisterAddress(t *testing.T) {
+	if err := RegisterAddress("lines", func(arg string) (Address, error) {
+		lines := make(map[int]bool)
+		for _, field := range strings.Fields(arg) {
+			n, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, err
+			}
+			lines[n] = true
+		}
+		return AddressFunc(func(ctx *ExecContext) bool { return lines[ctx.LineNumber()] }), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer delete(addressDefs, "lines")
+	if err := RegisterAddress("upper", func(string) (Address, error) {
+		return AddressFunc(func(ctx *ExecContext) bool { return bytes.Equal(ctx.PatternSpace(), bytes.ToUpper(ctx.PatternSpace())) }), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer delete(addressDefs, "upper")
+
+	input := "a\nB\nc\nd\nE\nf\n"
+	for _, test := range []struct {
+		script, expected string
+	}{
+		{"@lines{1 3}d", "B\nd\nE\nf\n"},
+		{"@lines{1 3}!d", "a\nc\n"},
+		{"@upper d", "a\nc\nd\nf\n"},
+		{"@upper,@upper d", "a\nf\n"},
+		{"/c/,@upper d", "a\nB\nf\n"},
+		{"@lines{2},4d", "a\nE\nf\n"},
+		{"@upper {\ns/^/!/\n}", "a\n!B\nc\nd\n!E\nf\n"},
+	} {
+		checkString(t, test.script, test.expected, runSed(t, test.script, input))
+	}
+
+	c, err := NewCmd(new(Sed), []byte("@lines{2 3},/x/!p"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkString(t, "canonical form", "@lines{2 3},/x/!p", c.source())
+
+	for _, test := range []struct {
+		script string
+		err    error
+	}{
+		{"@nothing p", ErrUnknownAddress},
+		{"@lines{1 p", ErrUnterminatedAddress},
+		{"@lines{x}p", strconv.ErrSyntax},
+		{"@upper,p", ErrMissingRangeEnd},
+	} {
+		_, err := NewCmd(new(Sed), []byte(test.script))
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.script, test.err, err)
+		}
+	}
+	if err := RegisterAddress("upper", func(string) (Address, error) { return nil, nil }); !errors.Is(err, ErrAddressRegistered) {
+		t.Errorf("upper: expected %v, got %v", ErrAddressRegistered, err)
+	}
+}
@@ -0,0 +1,298 @@
+// script.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+
+// Package sed implements the entire program, from this specific part, we find where each command of a script line ends
+package sed
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// scriptPosition is where a command or comment starts in the script, both counted from 1.
+type scriptPosition struct {
+	line   int
+	column int
+}
+
+// scriptItem is one element of a script as it was written: a command, a comment or a blank line.
+// The formatter and the other tools built on the parser walk these instead of the script text.
+type scriptItem struct {
+	cmd        Cmd    // nil for comments and blank lines
+	comment    string // text after the '#', for comments and for commands followed by one on the same line
+	blank      bool
+	pos        scriptPosition
+	extensions []string // the extensions to POSIX sed the command uses
+}
+
+// ScriptError is an error found while parsing a script, with the position it was found at.
+type ScriptError struct {
+	Line   int
+	Column int
+	Text   string // the script line the error is on
+	Err    error
+}
+
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("Script error: %s -> %d: %s", e.Err.Error(), e.Line, e.Text)
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isBlank(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+// skipDelimited returns the index just past the delim closing the part of line starting at i, or -1 when it isn't closed.
+// Backslash escapes are skipped, and so are the bracket expressions of a regex, in which the delimiter stands for itself.
+func skipDelimited(line []byte, i int, delim byte, regex bool) int {
+	for i < len(line) {
+		switch c := line[i]; {
+		case c == '\\':
+			i += 2
+			continue
+		case c == delim:
+			return i + 1
+		case c == '[' && regex:
+			i = skipBracket(line, i)
+			continue
+		}
+		i++
+	}
+	return -1
+}
+
+// skipBracket returns the index just past the bracket expression starting at line[i], or i+1 when it isn't closed.
+func skipBracket(line []byte, i int) int {
+	j := i + 1
+	if j < len(line) && line[j] == '^' {
+		j++
+	}
+	if j < len(line) && line[j] == ']' {
+		j++
+	}
+	for j < len(line) {
+		switch {
+		case line[j] == ']':
+			return j + 1
+		case line[j] == '[' && j+1 < len(line) && (line[j+1] == ':' || line[j+1] == '.' || line[j+1] == '='):
+			// [:alpha:] and friends may contain a ']'
+			end := bytes.Index(line[j+2:], []byte{line[j+1], ']'})
+			if end < 0 {
+				return i + 1
+			}
+			j += end + 4
+		default:
+			j++
+		}
+	}
+	return i + 1
+}
+
+// splitDelimited splits an s or y command into its pieces: the command letter, its delimited parts and what follows
+// the last delimiter (the flags of s). The delimiter is the character after the command letter, and escaped
+// delimiters inside the parts are unescaped. When regex is set, the first part is a regular expression.
+func splitDelimited(line []byte, parts int, regex bool) [][]byte {
+	if len(line) < 2 {
+		return [][]byte{line}
+	}
+	delim := line[1]
+	pieces := [][]byte{line[:1]}
+	i := 2
+	for p := 0; p < parts; p++ {
+		end := skipDelimited(line, i, delim, regex && p == 0)
+		if end < 0 {
+			// Not enough parts, which the command will complain about
+			return append(pieces, unescapeDelimiter(line[i:], delim))
+		}
+		pieces = append(pieces, unescapeDelimiter(line[i:end-1], delim))
+		i = end
+	}
+	return append(pieces, line[i:])
+}
+
+// unescapeDelimiter turns every \delim of part into a plain delim, which is what the escape stands for.
+func unescapeDelimiter(part []byte, delim byte) []byte {
+	if bytes.IndexByte(part, '\\') < 0 {
+		return part
+	}
+	out := make([]byte, 0, len(part))
+	for i := 0; i < len(part); i++ {
+		if part[i] == '\\' && i+1 < len(part) && part[i+1] == delim {
+			i++
+		}
+		out = append(out, part[i])
+	}
+	return out
+}
+
+// escapeDelimiter is the reverse of unescapeDelimiter, for writing part back between delimiters.
+func escapeDelimiter(part string, delim byte) string {
+	return strings.ReplaceAll(part, string(delim), "\\"+string(delim))
+}
+
+// skipAddress returns the index just past the address starting at line[i], which is i when there's none.
+func skipAddress(line []byte, i int) (int, error) {
+	switch {
+	case i >= len(line):
+		return i, nil
+	case line[i] == '$':
+		return i + 1, nil
+	case line[i] == '/':
+		end := skipDelimited(line, i+1, '/', true)
+		if end < 0 {
+			return i, ErrUnterminatedRegularExpression
+		}
+		return end, nil
+	case line[i] == '@':
+		i++
+		for i < len(line) && isRegisterName(line[i]) {
+			i++
+		}
+		if i < len(line) && line[i] == '{' {
+			end := skipDelimited(line, i+1, '}', false)
+			if end < 0 {
+				return i, ErrUnterminatedAddress
+			}
+			return end, nil
+		}
+		return i, nil
+	}
+	for i < len(line) && isDigit(line[i]) {
+		i++
+	}
+	return i, nil
+}
+
+// skipAddresses returns the index of the command letter of line, after its addresses and any '!'.
+func skipAddresses(line []byte) (int, error) {
+	i, err := skipAddress(line, 0)
+	if err != nil {
+		return i, err
+	}
+	if i > 0 && i < len(line) && line[i] == ',' {
+		if i, err = skipAddress(line, i+1); err != nil {
+			return i, err
+		}
+	}
+	for i < len(line) && (isBlank(line[i]) || line[i] == '!') {
+		i++
+	}
+	return i, nil
+}
+
+// commandLength returns the length of the command line starts with, addresses included. What follows it is
+// either nothing, blanks, a ';', a '}' or a comment.
+func (s *Sed) commandLength(line []byte) (int, error) {
+	i, err := skipAddresses(line)
+	if err != nil {
+		return 0, err
+	}
+	if i >= len(line) {
+		return 0, ErrMissingCommand
+	}
+	if def, n := registeredCommand(line[i:]); def != nil {
+		if def.Parse != nil {
+			// The argument is the rest of the line
+			return len(line), nil
+		}
+		return endOfCommand(line, i+n)
+	}
+	switch line[i] {
+	case 'a', 'i', 'c', 'r', 'm':
+		// The text or file name is the rest of the line
+		return len(line), nil
+	case ':', 'b', 't':
+		// A label ends at a ';' or a blank, and a branch's also at a '}'. POSIX labels run to the end of the line.
+		isBranch := line[i] != ':'
+		i++
+		for i < len(line) && isBlank(line[i]) {
+			i++
+		}
+		if s.posix {
+			return len(line), nil
+		}
+		for i < len(line) && line[i] != ';' && !isBlank(line[i]) && !(isBranch && line[i] == '}') {
+			i++
+		}
+		if i < len(line) && !isBlank(line[i]) {
+			s.checkPOSIX(fmt.Sprintf("label ended by %c", line[i]))
+		}
+	case 's':
+		if i+1 >= len(line) {
+			return 0, ErrUnterminatedRegularExpression
+		}
+		delim := line[i+1]
+		end := skipDelimited(line, i+2, delim, true)
+		if end >= 0 {
+			end = skipDelimited(line, end, delim, false)
+		}
+		if end < 0 {
+			return 0, ErrUnterminatedRegularExpression
+		}
+		i = end
+		for i < len(line) && (isDigit(line[i]) || line[i] >= 'a' && line[i] <= 'z' || line[i] >= 'A' && line[i] <= 'Z') {
+			i++
+		}
+	case 'y':
+		if i+1 >= len(line) {
+			return 0, ErrUnterminatedRegularExpression
+		}
+		delim := line[i+1]
+		end := skipDelimited(line, i+2, delim, false)
+		if end >= 0 {
+			end = skipDelimited(line, end, delim, false)
+		}
+		if end < 0 {
+			return 0, ErrUnterminatedRegularExpression
+		}
+		i = end
+	case 'q':
+		// Optional exit code: q5, q 5, or the older q/5
+		i++
+		for i < len(line) && (isBlank(line[i]) || isDigit(line[i]) || line[i] == '/') {
+			i++
+		}
+	case '{':
+		// A block may be followed right away by its first command
+		return i + 1, nil
+	case 'g', 'G', 'h', 'H', 'x':
+		// Optional register name: h:hdr
+		i++
+		if i < len(line) && line[i] == ':' {
+			i++
+			for i < len(line) && isRegisterName(line[i]) {
+				i++
+			}
+		}
+	case '}', '=', 'd', 'D', 'n', 'N', 'p', 'P':
+		i++
+	default:
+		return 0, ErrUnknownScriptCommand
+	}
+	return endOfCommand(line, i)
+}
+
+// endOfCommand returns i, where a command of line ends, after checking that only blanks come before the next
+// command, a '}' or a comment.
+func endOfCommand(line []byte, i int) (int, error) {
+	j := i
+	for j < len(line) && isBlank(line[j]) {
+		j++
+	}
+	if j < len(line) && line[j] != ';' && line[j] != '}' && line[j] != '#' {
+		return 0, ErrExtraCharacters
+	}
+	return i, nil
+}
@@ -11,12 +11,13 @@ package sed
 import (
 	"bufio"
 	"bytes"
-	"container/list"
 	"flag"
 	"fmt"
 	"io"
 	"os"
-	"strconv"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 
@@ -30,41 +31,106 @@ const (
 )
 
 var versionString string
-var quiet = flag.Bool("n", false, "Suppress automatic printing of pattern space.")
-var script = flag.String("e", "", "Expression to process input. Can be provided as a string.")
-var scriptFile = flag.String("f", "", "Read expression/script from a file. Ignored if -e is specified.")
-var editInplace = flag.Bool("i", false, "Edit files in place. If not set, output is printed to stdout.")
+
+// commandLine holds the options of sed. They aren't on flag.CommandLine, which belongs to the programs importing the
+// package, until Main makes them so for the help page.
+var commandLine = flag.NewFlagSet("sed", flag.ContinueOnError)
+
+var quiet = commandLine.Bool("n", false, "Suppress automatic printing of pattern space.")
+var editInplace = new(inPlaceFlag)
+var crlf = commandLine.Bool("crlf", false, "Strip the carriage return of CRLF line endings before each cycle and restore it on output.")
+var posix = commandLine.Bool("posix", false, "Disable every extension to POSIX sed, and reject regular expressions a POSIX sed would read differently, as BREs. Also enabled by setting POSIXLY_CORRECT.")
+var debug = commandLine.Bool("debug", false, "Print the program in canonical form, then annotate every cycle with the commands executed and the pattern and hold space after each one.")
+var unbuffered = commandLine.Bool("u", false, "Flush the output after every line instead of when the buffer fills up, for interactive pipes.")
+var jobs = commandLine.Int("j", 1, "With -i, edit this many files at a time. 0 means one per CPU.")
+var noSplit = commandLine.Bool("no-split", false, "Never split an input between goroutines. Otherwise scripts that keep nothing from one line to the next (no h, H, g, G, x, n, N, D, =, q, line numbers, ranges or $) have big inputs split between all CPUs.")
+var diffOnly = commandLine.Bool("diff", false, "Instead of editing the files in place, print what -i would change as a unified diff, and exit with status 1 when that is anything.")
+var recursive = commandLine.Bool("R", false, "Replace the directories among the operands with the text files below them, skipping .git directories, symbolic links and binary files (a NUL in the first 8000 bytes). Without operands, the current directory.")
+var gitignore = commandLine.Bool("gitignore", false, "With -R, also skip what the .gitignore files of the tree ignore.")
+var includeGlobs, excludeGlobs globsFlag
+var confirm = commandLine.Bool("confirm", false, "Show every match an s command is about to replace and ask on the terminal whether to: y(es), n(o), a(ll the rest), q(uit replacing).")
+var showStats = commandLine.Bool("stats", false, "When done, print to stderr how many lines each command matched, the substitutions and lines deleted and inserted, and what changed in each file.")
+var reportFormat = commandLine.String("report", "", "When done, print the --stats to stderr in this format: json.")
+var jsonEvents = commandLine.Bool("json", false, "Instead of the output, print a JSON event for the beginning and end of each file, each replacement of s and each line p prints. With -i, the files are still edited.")
+var step = commandLine.Bool("step", false, "Run the script in an interactive debugger on the terminal, with breakpoints on script lines, input lines and addresses.")
+var showHelp = commandLine.Bool("h", false, "Show this help page and exit.")
+var showVersion = commandLine.Bool("version", false, "Print the version and exit.")
 var lineWrap = 0 // var lineWrap = flag.Uint("l", 0, "Specify the default line-wrap length for the l command. A length of 0 (zero) means to never wrap long lines. If not specified, it is taken to be 70.")
 var usageShown = false
 var newLine = []byte{'\n'}
+var crlfLineEnding = []byte{'\r', '\n'}
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
 
 func init() {
 	versionString = fmt.Sprintf("%d.%d.%d", versionMajor, versionMinor, versionPoint)
+	commandLine.Var(&fragmentFlag{}, "e", "Add the expression to the script. Can be given more than once.")
+	commandLine.Var(&fragmentFlag{fromFile: true}, "f", "Add the contents of a file to the script, \"-\" reads it from stdin. Can be given more than once.")
+	commandLine.Var(&fragmentFlag{command: "m "}, "replace-table", "Add an m command with this table of old<TAB>new lines to the script, replacing every old text with its new one in a single pass.")
+	commandLine.Var(&includeGlobs, "include", "With -R, only take the files matching this glob, which matches file names without a slash and paths from the directory given with one. Can be given more than once.")
+	commandLine.Var(&excludeGlobs, "exclude", "With -R, skip the files and directories matching this glob, which matches names without a slash and paths from the directory given with one. Can be given more than once.")
+	commandLine.Var(editInplace, "i", "Edit files in place, keeping a backup when a suffix is attached (-i.bak). If not set, output is printed to stdout.")
+}
+
+// instruction is one step of the program a script is parsed into. Running the program is a loop over a slice of
+// them, with the jumps of blocks and branches resolved to indexes in that slice beforehand.
+type instruction struct {
+	cmd  Cmd
+	addr *address // the address of cmd, kept here so that matching it takes no interface call
+	skip int      // for a '{', the index of its '}', where the program carries on when the address doesn't match
 }
 
 // Sed holds the current file structure and operations
 type Sed struct {
 	inputFile               *os.File
 	input                   *bufio.Reader
+	nextInputs              []*inputFile // the files read after the current one without -i, as one input with it
 	lineNumber              int
-	currentLine             string
-	beforeCommands          *list.List
-	commands                *list.List
-	afterCommands           *list.List
+	program                 []instruction
 	outputFile              *os.File
+	output                  *bufio.Writer // buffers everything written to outputFile, flushed by flush
+	unbuffered              bool          // flush the output after every line, set by -u, --debug and --step
 	patternSpace, holdSpace []byte
+	registers               map[string][]byte // the named hold registers of h:NAME and the like, created as they are used
+	spans                   map[*address]bool // the span addresses the current line is in
+	scratch                 []byte            // where s builds the new pattern space, swapped with the old one afterwards
 	scriptLines             [][]byte
 	scriptLineNumber        int
+	scriptItems             []scriptItem           // the script as written, comments and blank lines included
+	scriptPositions         map[Cmd]scriptPosition // where each command starts in the script
+	stepper                 *stepper               // the --step debugger, nil when not stepping
+	confirmer               *confirmer             // asks before each replacement of s with --confirm, nil otherwise
+	stats                   *runStats              // what the script does, counted for --stats and --report, nil otherwise
+	events                  *eventWriter           // where --json reports what the script does, nil otherwise
+	observers               []runObserver          // told what the script does: --debug, stats, events and AddObserver's
+	ctx                     ExecContext            // what registered commands, addresses and observers see, see context
+	commandInfos            map[Cmd]CommandInfo    // the commands as observers see them, made as they first run
+	linesRead               int                    // the lines read from all of the input
+	linesWritten            int                    // the lines written to the output
+	replacements            int                    // the matches s and m commands have replaced
+	jump                    int                    // set by branches, the index of the instruction to carry on after, -1 when none
+	appendQueue             [][]byte               // text queued by a commands for the end of the cycle
+	substituted             bool                   // an s command replaced something since the cycle started or t last branched
+	restart                 bool                   // D asked for the next cycle to start without reading a new line
+	lineCR                  bool                   // the current input line ended in "\r\n" and --crlf is set
+	missingNewline          bool                   // the current input line is the last one and has no line ending
+	pendingNewline          bool                   // the line ending of the last output line was held back
+	quit                    bool                   // a command asked to stop instead of starting a new cycle
+	chunkWorkers            int                    // the goroutines runInput splits an input between, 0 when the script needs it whole
+	quiet                   bool                   // -n, or a #n first line: the pattern space isn't written at the end of each cycle
+	posix                   bool                   // --posix: extensions are rejected by the parser, and N at the last line quits without printing
+	crlf                    bool                   // --crlf: "\r\n" line endings are stripped and restored
+	extensions              []string               // the extensions to POSIX sed checkPOSIX let through in the command being parsed
+	exitCode                int
 }
 
 // Init initializes the Sed instance by setting up the command lists and output file.
 func (s *Sed) Init() {
-	s.beforeCommands = new(list.List)
-	s.commands = new(list.List)
-	s.afterCommands = new(list.List)
-	s.outputFile = os.Stdout
+	s.program = nil
+	s.jump = -1
+	s.setOutput(os.Stdout)
 	s.patternSpace = make([]byte, 0)
 	s.holdSpace = make([]byte, 0)
+	s.scriptPositions = make(map[Cmd]scriptPosition)
 }
 
 func copyByteSlice(a []byte) []byte {
@@ -96,10 +162,20 @@ func trimSpaceFromBeginning(s []byte) []byte {
 	return s[start:end]
 }
 
-func (s *Sed) parseScript(scriptBuffer []byte) (err error) {
+// parseScript parses the script into s.program, keeping what was written, comments included, in s.scriptItems.
+// Commands on a line are separated by ';', a '#' starts a comment running to the end of the line, and a few commands
+// (a, i, c, r) take the rest of the line. Blocks are matched and branches resolved once everything is parsed.
+func (s *Sed) parseScript(scriptBuffer []byte) error {
+	return s.parseScriptReporting(scriptBuffer, func(err *ScriptError) error { return err })
+}
+
+// parseScriptReporting is parseScript handing every error to report. When report returns nil, parsing goes on
+// with the next line, so that tools like the linter see every problem of a script at once.
+func (s *Sed) parseScriptReporting(scriptBuffer []byte, report func(err *ScriptError) error) error {
 	// Split the script buffer into lines
 	s.scriptLines = bytes.Split(scriptBuffer, newLine)
 	s.scriptLineNumber = 0
+	var blocks []int // the '{' still waiting for their '}'
 
 	for {
 		line, err := s.getNextScriptLine()
@@ -109,56 +185,199 @@ func (s *Sed) parseScript(scriptBuffer []byte) (err error) {
 		if err != nil {
 			return err
 		}
-
-		// Trim leading and trailing whitespace
-		line = trimSpaceFromBeginning(line)
-		if len(line) == 0 {
-			// Skip empty lines
+		lineNumber := s.scriptLineNumber
+		rest := trimSpaceFromBeginning(line)
+		if len(rest) == 0 {
+			s.scriptItems = append(s.scriptItems, scriptItem{blank: true, pos: scriptPosition{line: lineNumber, column: 1}})
 			continue
 		}
 
-		// Check if the line is a comment and skip it
-		if len(line) > 0 && line[0] == '#' {
-			// Special case for -n flag
-			if len(line) > 1 && line[1] == 'n' && s.scriptLineNumber == 1 {
-				*quiet = true
+		commandsOnLine := 0
+		for {
+			for len(rest) > 0 && (rest[0] == ';' || unicode.IsSpace(rune(rest[0]))) {
+				rest = rest[1:]
 			}
-			continue
+			if len(rest) == 0 {
+				break
+			}
+			pos := scriptPosition{line: lineNumber, column: len(line) - len(rest) + 1}
+			scriptError := func(err error) error {
+				return report(&ScriptError{Line: pos.line, Column: pos.column, Text: string(line), Err: err})
+			}
+
+			if rest[0] == '#' {
+				comment := string(bytes.TrimRight(rest[1:], " \t\r"))
+				// Special case for -n flag
+				if lineNumber == 1 && pos.column == 1 && comment == "n" {
+					s.quiet = true
+				}
+				if commandsOnLine > 0 {
+					// A comment after a command is an extension, POSIX only has comment lines
+					s.extensions = nil
+					if err := s.checkPOSIX("comment after a command"); err != nil {
+						if err := scriptError(err); err != nil {
+							return err
+						}
+						break
+					}
+					item := &s.scriptItems[len(s.scriptItems)-1]
+					item.comment = comment
+					item.extensions = append(item.extensions, s.extensions...)
+				} else {
+					s.scriptItems = append(s.scriptItems, scriptItem{comment: comment, pos: pos})
+				}
+				break
+			}
+
+			// Process the command
+			s.extensions = nil
+			n, err := s.commandLength(rest)
+			var c Cmd
+			if err == nil {
+				c, err = NewCmd(s, rest[:n])
+			}
+			if err != nil {
+				if err := scriptError(err); err != nil {
+					return err
+				}
+				// carry on with the next line, the rest of this one can't be told apart from the broken command
+				break
+			}
+			rest = rest[n:]
+			commandsOnLine++
+
+			i := len(s.program)
+			s.program = append(s.program, instruction{cmd: c, addr: c.getAddress()})
+			s.scriptPositions[c] = pos
+			s.scriptItems = append(s.scriptItems, scriptItem{cmd: c, pos: pos, extensions: s.extensions})
+			switch c.(type) {
+			case *BlockCmd:
+				blocks = append(blocks, i)
+			case *BlockEndCmd:
+				if len(blocks) == 0 {
+					if err := scriptError(ErrUnexpectedBrace); err != nil {
+						return err
+					}
+					continue
+				}
+				open := blocks[len(blocks)-1]
+				s.program[open].cmd.(*BlockCmd).end = i
+				s.program[open].skip = i
+				blocks = blocks[:len(blocks)-1]
+			}
+		}
+	}
+	for i := len(blocks) - 1; i >= 0; i-- {
+		if err := report(s.scriptError(s.program[blocks[i]].cmd, ErrUnmatchedBrace)); err != nil {
+			return err
 		}
+	}
+	return s.resolveBranches(report)
+}
 
-		// Remove comments from the line
-		if commentIndex := bytes.IndexByte(line, '#'); commentIndex != -1 {
-			line = line[:commentIndex]
-			line = trimSpaceFromBeginning(line)
-			if len(line) == 0 {
-				// Skip lines that become empty after removing comments
+// scriptError reports err at the position of c in the script.
+func (s *Sed) scriptError(c Cmd, err error) *ScriptError {
+	pos := s.scriptPositions[c]
+	text := ""
+	if pos.line > 0 && pos.line <= len(s.scriptLines) {
+		text = string(s.scriptLines[pos.line-1])
+	}
+	return &ScriptError{Line: pos.line, Column: pos.column, Text: text, Err: err}
+}
+
+// resolveBranches points every branch at the label it names, or at the end of the script when it names none.
+func (s *Sed) resolveBranches(report func(err *ScriptError) error) error {
+	labels := make(map[string]int)
+	for i, in := range s.program {
+		if label, ok := in.cmd.(*LabelCmd); ok {
+			if _, found := labels[label.label]; found {
+				if err := report(s.scriptError(label, ErrDuplicateLabel)); err != nil {
+					return err
+				}
 				continue
 			}
+			labels[label.label] = i
 		}
-
-		// Process the command
-		c, err := NewCmd(s, line)
-		if err != nil {
-			fmt.Printf("Script error: %s -> %d: %s\n", err.Error(), s.scriptLineNumber, line)
-			os.Exit(-1)
+	}
+	for _, in := range s.program {
+		if branch, ok := in.cmd.(*BCmd); ok {
+			if branch.label == "" {
+				branch.target = len(s.program) - 1
+				continue
+			}
+			target, found := labels[branch.label]
+			if branch.target = target; !found {
+				branch.target = -1
+				if err := report(s.scriptError(branch, fmt.Errorf("%w: %s", ErrUndefinedLabel, branch.label))); err != nil {
+					return err
+				}
+			}
 		}
+	}
+	return nil
+}
 
-		// Add the command to the appropriate list
-		if _, ok := c.(*ICmd); ok {
-			s.beforeCommands.PushBack(c)
-		} else if _, ok := c.(*ACmd); ok {
-			s.afterCommands.PushBack(c)
-		} else {
-			s.commands.PushBack(c)
-		}
+// flushAppendQueue writes the text queued by a commands and empties the queue.
+func (s *Sed) flushAppendQueue() {
+	for _, text := range s.appendQueue {
+		s.writeLine(text)
+	}
+	s.appendQueue = s.appendQueue[:0]
+}
+
+// lineEnding returns the line ending of the current input line, so CRLF files keep their endings on output.
+func (s *Sed) lineEnding() []byte {
+	if s.lineCR {
+		return crlfLineEnding
+	}
+	return newLine
+}
+
+// setOutput makes f the output, buffered.
+func (s *Sed) setOutput(f *os.File) {
+	s.outputFile = f
+	if s.output == nil {
+		s.output = bufio.NewWriterSize(f, 64*1024)
+	} else {
+		s.output.Reset(f)
+	}
+}
+
+// flush writes out whatever is buffered. It is called when the input runs out, when sed quits and before any error
+// ends the program, and after every line when unbuffered. A failed write sticks, the flush at the end reports it.
+func (s *Sed) flush() error {
+	if err := s.output.Flush(); err != nil {
+		return fmt.Errorf("Error writing output: %w", err)
 	}
 	return nil
 }
 
+// write writes b to the output, first emitting any line ending that was held back for a last line without one.
+func (s *Sed) write(b []byte) {
+	if s.pendingNewline {
+		s.pendingNewline = false
+		s.output.Write(newLine)
+	}
+	s.output.Write(b)
+}
+
+// writeLine writes line followed by the line ending of the current input line.
+func (s *Sed) writeLine(line []byte) {
+	for _, o := range s.observers {
+		o.Write(s.context(), line)
+	}
+	s.write(line)
+	s.output.Write(s.lineEnding())
+	s.linesWritten++
+	if s.unbuffered {
+		s.flush()
+	}
+}
+
 func (s *Sed) printLine(line []byte) {
 	l := len(line)
 	if lineWrap <= 0 || l < int(lineWrap) {
-		fmt.Fprintf(s.outputFile, "%s\n", line)
+		s.writeLine(line)
 	} else {
 		// print the line in segments
 		for i := 0; i < l; i += int(lineWrap) {
@@ -166,72 +385,413 @@ func (s *Sed) printLine(line []byte) {
 			if endOfLine > l {
 				endOfLine = l
 			}
-			fmt.Fprintf(s.outputFile, "%s\n", line[i:endOfLine])
+			s.writeLine(line[i:endOfLine])
+		}
+	}
+}
+
+// inSpan reports whether the current line is in the span a, opening it when its first address matches and closing
+// it when its last does. Like with N,M, a last line number that is already reached makes a range of a single line.
+// The last address is only tried from the line after the one that opened the span.
+func (s *Sed) inSpan(a *address) bool {
+	if s.spans[a] {
+		if a.last.addressType == addressLine && s.lineNumber >= a.last.rangeStart || a.last.addressType != addressLine && a.last.matches(s) {
+			delete(s.spans, a)
+		}
+		return true
+	}
+	if !a.first.matches(s) {
+		return false
+	}
+	if a.last.addressType != addressLine || s.lineNumber < a.last.rangeStart {
+		if s.spans == nil {
+			s.spans = make(map[*address]bool)
 		}
+		s.spans[a] = true
+	}
+	return true
+}
+
+// hold returns the named hold register, the hold space when name is "".
+func (s *Sed) hold(name string) []byte {
+	if name == "" {
+		return s.holdSpace
 	}
+	return s.registers[name]
+}
+
+// setHold replaces the named hold register, the hold space when name is "".
+func (s *Sed) setHold(name string, value []byte) {
+	if name == "" {
+		s.holdSpace = value
+		return
+	}
+	if s.registers == nil {
+		s.registers = make(map[string][]byte)
+	}
+	s.registers[name] = value
 }
 
 func (s *Sed) printPatternSpace() {
-	lines := bytes.Split(s.patternSpace, newLine)
-	for _, line := range lines {
-		s.printLine(line)
+	rest := s.patternSpace
+	for {
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			break
+		}
+		s.printLine(rest[:i])
+		rest = rest[i+1:]
+	}
+	if s.missingNewline {
+		// The input didn't end with a newline, so neither does the output unless something else gets written
+		for _, o := range s.observers {
+			o.Write(s.context(), rest)
+		}
+		s.write(rest)
+		s.pendingNewline = true
+		if s.unbuffered {
+			s.flush()
+		}
+		return
 	}
+	s.printLine(rest)
 }
 
-func (s *Sed) process() {
-	if *editInplace {
-		s.lineNumber = 0
+// skipBOM drops a UTF-8 byte order mark from the start of the input and copies it straight to the output,
+// so it doesn't get in the way of the script and still round-trips.
+func (s *Sed) skipBOM() {
+	if b, err := s.input.Peek(len(utf8BOM)); err == nil && bytes.Equal(b, utf8BOM) {
+		s.input.Discard(len(utf8BOM))
+		s.write(utf8BOM)
+	}
+}
+
+// atEOF reports whether the input has no more lines: the current file has none left, and neither have the files
+// read after it without -i. So $ is the last line of the last file, and of every file with -i and --diff.
+func (s *Sed) atEOF() bool {
+	if s.unbuffered {
+		// Peeking may wait for input, what was written so far must not wait with it
+		s.flush()
+	}
+	if _, err := s.input.Peek(1); err == nil {
+		return false
+	}
+	for _, in := range s.nextInputs {
+		in.open()
+		if in.err != nil {
+			// Not the end yet, the error shows when the file's turn comes
+			return false
+		}
+		if _, err := in.reader.Peek(1); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// inputFile is an input file operand waiting for its turn, opened once something needs to look into it.
+type inputFile struct {
+	name   string
+	file   *os.File
+	reader *bufio.Reader
+	err    error // opening the file failed, which is reported when its turn comes
+}
+
+// open opens the file, unless that was done or tried before.
+func (in *inputFile) open() {
+	if in.reader == nil && in.err == nil {
+		in.file, in.err = os.Open(in.name)
+		if in.err == nil {
+			in.reader = bufio.NewReader(in.file)
+		}
 	}
+}
+
+// setInputFiles makes the files names the input, read one after the other as a single one.
+func (s *Sed) setInputFiles(names []string) {
+	s.nextInputs = s.nextInputs[:0]
+	for _, name := range names {
+		s.nextInputs = append(s.nextInputs, &inputFile{name: name})
+	}
+}
+
+// nextInput makes the next input file the current one.
+func (s *Sed) nextInput() error {
+	in := s.nextInputs[0]
+	s.nextInputs = s.nextInputs[1:]
+	in.open()
+	if in.err != nil {
+		return in.err
+	}
+	inputFilename = in.name
+	s.inputFile, s.input = in.file, in.reader
+	s.beginFile(in.name)
+	return nil
+}
+
+// closeInput closes the current input file, once it is read or reading it failed.
+func (s *Sed) closeInput() error {
+	if s.input == nil {
+		return nil
+	}
+	s.inputFile.Close()
+	s.inputFile, s.input = nil, nil
+	return s.endFile()
+}
+
+// readLine appends the next line of input to dst, without its line ending, and returns the result. Reading into
+// a buffer that is already there, the pattern space's own most of the time, means no allocation once it is big
+// enough. With --crlf, a "\r\n" ending is stripped and remembered in lineCR so that it can be restored on output.
+func (s *Sed) readLine(dst []byte) ([]byte, error) {
+	if s.unbuffered {
+		s.flush()
+	}
+	start := len(dst)
 	var err error
-	s.patternSpace, err = s.input.ReadSlice('\n')
-	for err != io.EOF {
-		lineLength := len(s.patternSpace)
-		if lineLength > 0 {
-			s.patternSpace = s.patternSpace[0 : lineLength-1]
-		}
-		s.currentLine = string(s.patternSpace)
-		// track line number starting with line 1
-		s.lineNumber++
-		stop := false
-		// process i commands
-		for c := s.beforeCommands.Front(); c != nil; c = c.Next() {
-			// ask the sed if we should process this command, based on address
-			if cmd, ok := c.Value.(*ICmd); ok {
-				if c.Value.(Address).match(s.patternSpace, s.lineNumber) {
-					s.outputFile.Write(cmd.text)
+	for {
+		var chunk []byte
+		chunk, err = s.input.ReadSlice('\n')
+		dst = append(dst, chunk...)
+		if err == io.EOF && len(dst) == start && len(s.nextInputs) > 0 {
+			// The current file is done, the next one goes on with the same input
+			if err = s.closeInput(); err == nil {
+				err = s.nextInput()
+			}
+			if err != nil {
+				return dst, err
+			}
+			s.skipBOM()
+			continue
+		}
+		if err != bufio.ErrBufferFull {
+			break
+		}
+	}
+	if err != nil && (err != io.EOF || len(dst) == start) {
+		return dst[:start], err
+	}
+	// A last line without line ending gets one on output when the next file goes on after it
+	s.missingNewline = err == io.EOF && s.atEOF()
+	s.lineCR = false
+	s.linesRead++
+	if err == nil {
+		dst = dst[:len(dst)-1]
+		if s.crlf && len(dst) > start && dst[len(dst)-1] == '\r' {
+			dst = dst[:len(dst)-1]
+			s.lineCR = true
+		}
+	}
+	return dst, nil
+}
+
+// beginFile is called before the input file name is processed, for --stats and --json.
+func (s *Sed) beginFile(name string) {
+	for _, o := range s.observers {
+		o.beginFile(s.context(), name)
+	}
+}
+
+// endFile is called once the current input file is processed, for --stats and --json.
+func (s *Sed) endFile() error {
+	for _, o := range s.observers {
+		if err := o.endFile(s.context()); err != nil {
+			return fmt.Errorf("Error writing output: %w", err)
+		}
+	}
+	return nil
+}
+
+// runInput is run, with the input split between goroutines when the script allows it, see runChunked.
+func (s *Sed) runInput() error {
+	if s.chunkWorkers > 1 {
+		return s.runChunked(s.chunkWorkers)
+	}
+	return s.run()
+}
+
+// run runs the script over the input until it runs out or a command quits. It never ends the program, the
+// workers of -j run it side by side.
+func (s *Sed) run() error {
+	if editInplace.enabled {
+		s.lineNumber = 0
+		s.spans = nil
+	}
+	s.skipBOM()
+	return s.cycles()
+}
+
+// cycles runs the script over the input from where it is, line by line, until it runs out or a command quits.
+func (s *Sed) cycles() error {
+	for {
+		if s.restart {
+			// D left something in the pattern space, run the script on it without reading a new line
+			s.restart = false
+		} else {
+			line, err := s.readLine(s.patternSpace[:0])
+			if err != nil {
+				if err != io.EOF {
+					s.flush()
+					return fmt.Errorf("Error reading input: %w", err)
 				}
+				break
 			}
+			s.patternSpace = line
+			// track line number starting with line 1
+			s.lineNumber++
+		}
+		s.substituted = false
+		for _, o := range s.observers {
+			o.CycleStart(s.context())
 		}
-		for c := s.commands.Front(); c != nil; c = c.Next() {
+		if s.stepper != nil {
+			s.stepper.startCycle(s)
+		}
+		stop := false
+		for pc := 0; pc < len(s.program); pc++ {
+			in := &s.program[pc]
 			// ask the sed if we should process this command, based on address
-			if c.Value.(Address).match(s.patternSpace, s.lineNumber) {
-				var err error
-				stop, err = c.Value.(Cmd).processLine(s)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
-					fmt.Fprintf(os.Stderr, "Line: %d:%s\n", s.lineNumber, s.currentLine)
-					fmt.Fprintf(os.Stderr, "Command: %s\n", c.Value.(Cmd).String())
-					os.Exit(-1)
+			if in.addr != nil && !in.addr.matches(s) {
+				// skip the whole block when its address doesn't match
+				if in.skip > 0 {
+					pc = in.skip
 				}
-				if stop {
-					break
+				continue
+			}
+			cmd := in.cmd
+			if s.stepper != nil && !s.stepper.beforeCommand(s, cmd) {
+				s.quit = true
+				return s.flush()
+			}
+			for _, o := range s.observers {
+				o.Command(s.context(), s.commandInfo(cmd))
+			}
+			var err error
+			stop, err = cmd.processLine(s)
+			for _, o := range s.observers {
+				o.ran(s.context(), pc, cmd, stop)
+			}
+			if err != nil {
+				s.flush()
+				return fmt.Errorf("Error: %w\nLine: %d:%s\nCommand: %s", err, s.lineNumber, s.patternSpace, cmd.String())
+			}
+			if stop {
+				break
+			}
+			if s.jump >= 0 {
+				for _, o := range s.observers {
+					o.Branch(s.context(), s.commandInfo(cmd), cmd.(*BCmd).label)
 				}
+				pc = s.jump
+				s.jump = -1
 			}
 		}
-		if !*quiet && !stop {
+		for _, o := range s.observers {
+			o.scriptEnd(s.context())
+		}
+		if !s.quiet && !stop {
 			s.printPatternSpace()
 		}
-		// process a commands
-		for c := s.afterCommands.Front(); c != nil; c = c.Next() {
-			// ask the sed if we should process this command, based on address
-			if cmd, ok := c.Value.(*ACmd); ok {
-				if c.Value.(Address).match(s.patternSpace, s.lineNumber) {
-					fmt.Fprintf(s.outputFile, "%s\n", cmd.text)
-				}
-			}
+		// text queued by a commands goes out at the end of the cycle, even when it was cut short
+		s.flushAppendQueue()
+		for _, o := range s.observers {
+			o.CycleEnd(s.context())
+		}
+		if s.quit {
+			break
+		}
+	}
+	return s.flush()
+}
+
+// backupFile copies the file name to its backup before it gets edited in place. Like GNU sed, a "*" in the
+// suffix is replaced with the base name of the file, otherwise the suffix is appended to it.
+func backupFile(name, suffix string, mode os.FileMode) error {
+	backupName := name + suffix
+	if strings.Contains(suffix, "*") {
+		backupName = filepath.Join(filepath.Dir(name), strings.ReplaceAll(suffix, "*", filepath.Base(name)))
+	}
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(backupName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// editToTemp runs the script over the file name for -i, writing the result to a temporary file next to it, and
+// returns the name of that file for commitEdit.
+func (s *Sed) editToTemp(name string) (string, error) {
+	in, err := os.Open(name)
+	if err != nil {
+		return "", fmt.Errorf("error, could not open input file: %s.", name)
+	}
+	defer in.Close()
+	temp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("Error opening temp file for inplace editing: %w", err)
+	}
+	s.inputFile, s.input = in, bufio.NewReader(in)
+	s.setOutput(temp)
+	err = s.runInput()
+	s.inputFile, s.input = nil, nil
+	if closeErr := temp.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("Error writing output: %w", closeErr)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return "", err
+	}
+	return temp.Name(), nil
+}
+
+// commitEdit puts what editToTemp wrote to temp in place of the file name, after backing it up when -i was given
+// a suffix. The file is rewritten rather than replaced so that it keeps its mode, owner and links.
+func commitEdit(name, temp string) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		os.Remove(temp)
+		return fmt.Errorf("Error getting information about input file: %s %v", name, err)
+	}
+	if editInplace.suffix != "" {
+		if err := backupFile(name, editInplace.suffix, info.Mode()); err != nil {
+			os.Remove(temp)
+			return fmt.Errorf("Error writing backup of input file: %s %v", name, err)
 		}
-		s.patternSpace, err = s.input.ReadSlice('\n')
 	}
+	src, err := os.Open(temp)
+	if err != nil {
+		return fmt.Errorf("Error opening temp file for inplace editing: %w", err)
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name, os.O_WRONLY|os.O_TRUNC, info.Mode())
+	if err != nil {
+		os.Remove(temp)
+		return fmt.Errorf("Error opening input file for inplace editing: %w", err)
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Error copying temp file back to input file: %v\nFull output is in %s", err, temp)
+	}
+	os.Remove(temp)
+	return nil
+}
+
+// subcommands are the tools run as `gosed NAME [args]` instead of running a script.
+var subcommands = map[string]func(args []string) int{
+	"compile": compileMain,
+	"explain": explainMain,
+	"fmt":     fmtMain,
+	"lint":    lintMain,
+	"lsp":     lspMain,
 }
 
 // Main is the entrypoint of this program. The ../../main.go calls `sed.Main()` to get here and get things done.
@@ -240,6 +800,8 @@ func Main() {
 	s := new(Sed)
 	s.Init()
 
+	// The help page lists the options of flag.CommandLine
+	flag.CommandLine = commandLine
 	printHelpPage := func() {
 		// only show and calculate usage once
 		if !usageShown {
@@ -248,6 +810,7 @@ func Main() {
 				Name:        "sed",
 				Synopsis:    "[options] <script> <input_file>",
 				Description: "Unix's standard Stream Editor",
+				Behavior:    "Options can be combined (-ne p) and given after operands. Long forms: --quiet/--silent (-n), --expression (-e), --file (-f), --in-place (-i), --unbuffered (-u), --jobs (-j), --recursive (-R), --dry-run (--diff), --help (-h). Subcommands, each with its own -h: fmt, lint, compile, lsp, explain",
 				Notes:       "This version of sed is a redistribution with modifications of `https://github.com/baldmountain/gosed`",
 				Since:       2009,
 			}
@@ -261,39 +824,38 @@ func Main() {
 			usageShown = true
 		}
 	}
-	flag.Usage = func() {
+	if os.Getenv("POSIXLY_CORRECT") != "" {
+		*posix = true
+	}
+	if len(os.Args) > 1 {
+		if subcommand, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(subcommand(os.Args[2:]))
+		}
+	}
+	operands, err := parseArgs(os.Args[1:])
+	if err != nil {
 		printHelpPage()
+		fmt.Fprintf(os.Stderr, "error, %s\n", err.Error())
+		os.Exit(-1)
+	}
+	if *showHelp {
+		printHelpPage()
+		os.Exit(0)
+	}
+	if *showVersion {
+		fmt.Fprintf(os.Stdout, "sed version %s\n", versionString)
+		os.Exit(0)
 	}
-	flag.Parse()
-
-	// The first parameter may be a script or an input file. This helps us track which
-	currentFileParameter := 0
-	var scriptBuffer []byte
-
-	// We need a script
-	if len(*script) == 0 {
-		// No -e so try -f
-		if len(*scriptFile) > 0 {
-			sb, err := os.ReadFile(*scriptFile)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error reading script file %s\n", *scriptFile)
-				os.Exit(-1)
-			}
-			scriptBuffer = sb
-		} else if flag.NArg() > 0 { // Changed from > 1 to > 0 to correctly handle the case with a single argument as script
-			script := flag.Arg(0)
-			scriptBuffer = []byte(script)
-
-			// Change semicolons to newlines for scripts on command line
-			scriptBuffer = bytes.ReplaceAll(scriptBuffer, []byte(";"), []byte("\n"))
 
-			// First parameter was the script, so move to the second parameter
-			currentFileParameter++
-		}
-	} else {
-		scriptBuffer = []byte(*script)
-		// Change semicolons to newlines for scripts on command line
-		scriptBuffer = bytes.ReplaceAll(scriptBuffer, []byte(";"), []byte("\n"))
+	// Without -e or -f the first operand is the script, the rest are input files
+	if len(scriptFragments) == 0 && len(operands) > 0 {
+		scriptFragments = append(scriptFragments, scriptFragment{text: operands[0]})
+		operands = operands[1:]
+	}
+	scriptBuffer, err := buildScript(scriptFragments)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(-1)
 	}
 
 	// If script still isn't set, we are screwed, exit.
@@ -304,73 +866,169 @@ func Main() {
 	}
 
 	// Parse script
-	s.parseScript(scriptBuffer)
+	s.quiet, s.posix, s.crlf = *quiet, *posix, *crlf
+	if err := s.parseScript(scriptBuffer); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(-1)
+	}
+	// The debug output and the debugger write straight to the terminal, in between the lines of the output
+	s.unbuffered = *unbuffered || *debug || *step
+	if *debug {
+		trace := &debugObserver{w: os.Stdout}
+		trace.program(s)
+		s.observe(trace)
+	}
+	if *step {
+		s.stepper, err = openStepper()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(-1)
+		}
+	}
+	if *reportFormat != "" && *reportFormat != "json" {
+		fmt.Fprintf(os.Stderr, "%v: %s\n", ErrUnknownReportFormat, *reportFormat)
+		os.Exit(-1)
+	}
+	if *showStats || *reportFormat != "" {
+		s.setStats(newRunStats(s))
+	}
+	if *jsonEvents {
+		s.setEvents(newEventWriter(bufio.NewWriter(os.Stdout)))
+		if !editInplace.enabled {
+			// The events take the place of the output
+			s.output.Reset(io.Discard)
+		}
+	}
+	if *confirm {
+		s.confirmer, err = openConfirmer()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(-1)
+		}
+	}
+	// Scripts that treat every line on its own get big inputs cut into chunks processed on every CPU
+	if !*noSplit && !s.unbuffered && s.confirmer == nil && s.stats == nil && s.events == nil && s.stateless() {
+		s.chunkWorkers = runtime.GOMAXPROCS(0)
+	}
 
-	if currentFileParameter >= flag.NArg() {
-		if *editInplace {
-			fmt.Fprintf(os.Stderr, "Warning: Option -i ignored\n")
+	if *recursive {
+		if len(operands) == 0 {
+			operands = []string{"."}
 		}
-		s.input = bufio.NewReader(os.Stdin)
-		s.process()
-	} else {
-		for ; currentFileParameter < flag.NArg(); currentFileParameter++ {
-			inputFilename = flag.Arg(currentFileParameter)
-			// actually do the processing
-			s.inputFile, err = os.Open(inputFilename)
+		operands, err = expandOperands(operands)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(-1)
+		}
+		if len(operands) == 0 {
+			// Nothing to edit, which is not a reason to read stdin instead
+			os.Exit(0)
+		}
+	} else if len(includeGlobs) > 0 || len(excludeGlobs) > 0 || *gitignore {
+		fmt.Fprintf(os.Stderr, "Warning: Options --include, --exclude and --gitignore ignored without -R\n")
+	}
+
+	// The edits of -i are put in place, or with --diff shown instead
+	commit := commitEdit
+	changed := false
+	if *diffOnly && len(operands) > 0 {
+		editInplace.enabled = true
+		diffOutput := bufio.NewWriter(os.Stdout)
+		commit = func(name, temp string) error {
+			differs, err := diffEdit(diffOutput, name, temp)
+			changed = changed || differs
+			return err
+		}
+	}
+	if s.stats != nil {
+		// Whether -i changes a file is known once its edit is done, before it is put in place
+		put := commit
+		commit = func(name, temp string) error {
+			same, err := sameContents(name, temp)
 			if err != nil {
-				printHelpPage()
-				fmt.Fprintf(os.Stderr, "error, could not open input file: %s.\n", inputFilename)
-				os.Exit(-1)
+				os.Remove(temp)
+				return fmt.Errorf("Error comparing temp file with input file: %w", err)
 			}
-			s.input = bufio.NewReader(s.inputFile)
-			var tempFilename string
-			if *editInplace {
-				tempFilename = inputFilename + ".tmp"
-				tmpc := 0
-				dir, _ := os.Stat(tempFilename)
-				for dir != nil {
-					tmpc++
-					tempFilename = inputFilename + "-" + strconv.Itoa(tmpc) + ".tmp"
-					dir, _ = os.Stat(tempFilename)
-				}
-				f, err := os.Create(tempFilename)
+			s.stats.changed(!same)
+			return put(name, temp)
+		}
+	}
+	// With --diff, the exit status tells whether anything would change. Errors go through here too, so that --stats
+	// still tells what was done before the error, which is when it matters most
+	exit := func(exitCode int) {
+		if s.stats != nil {
+			s.stats.write(os.Stderr, *reportFormat)
+		}
+		if exitCode == 0 && changed {
+			exitCode = 1
+		}
+		os.Exit(exitCode)
+	}
+
+	if len(operands) == 0 {
+		if editInplace.enabled || *diffOnly {
+			fmt.Fprintf(os.Stderr, "Warning: Options -i and --diff ignored\n")
+		}
+		s.input = bufio.NewReader(os.Stdin)
+		s.beginFile("-")
+		// The file ends for --stats and --json even when an error cut it short
+		err := s.runInput()
+		if endErr := s.endFile(); err == nil {
+			err = endErr
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			exit(-1)
+		}
+		exit(s.exitCode)
+	} else if editInplace.enabled && *jobs != 1 && !*debug && s.stepper == nil && s.confirmer == nil {
+		exitCode, err := s.editInParallel(operands, *jobs, commit)
+		if err != nil {
+			exit(-1)
+		}
+		exit(exitCode)
+	} else {
+		if *jobs != 1 && !editInplace.enabled {
+			fmt.Fprintf(os.Stderr, "Warning: Option -j ignored without -i\n")
+		}
+		if !editInplace.enabled {
+			// The files are one input, read one after the other as the script goes. Only when it is split between
+			// goroutines does each file run on its own, which the scripts that allow it can't tell
+			s.setInputFiles(operands)
+			for len(s.nextInputs) > 0 && !s.quit {
+				err := s.nextInput()
 				if err != nil {
-					s.inputFile.Close()
-					fmt.Fprintf(os.Stderr, "Error opening temp file file for inplace editing: %s\n", err.Error())
-					os.Exit(-1)
+					err = fmt.Errorf("Error reading input: %w", err)
+				} else {
+					err = s.runInput()
 				}
-				s.outputFile = f
-			}
-			s.process()
-			// done processing, close input file
-			s.inputFile.Close()
-			s.input = nil
-			if *editInplace {
-				s.outputFile.Seek(0, 0)
-				// find out about
-				dir, err := os.Stat(inputFilename)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error getting information about input file: %s %v\n", inputFilename, err)
-					// os.Remove(tempFilename);
-					os.Exit(-1)
+				if closeErr := s.closeInput(); err == nil {
+					err = closeErr
 				}
-				// reopen input file
-				s.inputFile, err = os.OpenFile(inputFilename, os.O_WRONLY|os.O_TRUNC, dir.Mode())
 				if err != nil {
-					fmt.Fprint(os.Stderr, "Error opening input file for inplace editing: %w\n", err.Error())
-					// os.Remove(tempFilename);
-					os.Exit(-1)
-				}
-
-				_, e := io.Copy(s.inputFile, s.outputFile)
-				s.outputFile.Close()
-				s.inputFile.Close()
-				if e != nil {
-					fmt.Fprintf(os.Stderr, "Error copying temp file back to input file: %s\nFull output is in %s", err.Error(), tempFilename)
-				} else {
-					os.Remove(tempFilename)
+					fmt.Fprintln(os.Stderr, err.Error())
+					exit(-1)
 				}
 			}
+			exit(s.exitCode)
+		}
+		for _, inputFilename = range operands {
+			s.beginFile(inputFilename)
+			temp, err := s.editToTemp(inputFilename)
+			if err == nil {
+				err = s.endFile()
+			}
+			if err == nil {
+				err = commit(inputFilename, temp)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				exit(-1)
+			}
+			if s.quit {
+				break
+			}
 		}
 	}
+	exit(s.exitCode)
 }
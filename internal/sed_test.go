@@ -7,12 +7,18 @@
 package sed
 
 import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
 func TestNewCmd(t *testing.T) {
 	pieces := []byte{'4', 'x', '5', 'o', '/', '0', '/', 'g'}
-	c, err := NewCmd(nil, pieces)
+	c, err := NewCmd(new(Sed), pieces)
 	if c != nil {
 		t.Error("1: Got a command when we shouldn't have " + c.String())
 	}
@@ -24,7 +30,7 @@ func TestNewCmd(t *testing.T) {
 
 	// s
 	pieces = []byte{'s', '/', 'o', '/', '0', '/', 'g'}
-	c, err = NewCmd(nil, pieces)
+	c, err = NewCmd(new(Sed), pieces)
 	sc := c.(*SCmd)
 	if sc == nil {
 		t.Error("Didn't get a command that we expected")
@@ -37,7 +43,7 @@ func TestNewCmd(t *testing.T) {
 
 func TestNewDCmd(t *testing.T) {
 	pieces := []byte{'d', '/', 'o', '/', '0', '/', 'g'}
-	c, err := NewCmd(nil, pieces)
+	c, err := NewCmd(new(Sed), pieces)
 	dc := c.(*DCmd)
 	if dc != nil {
 		t.Error("2: Got a command when we shouldn't have " + c.String())
@@ -49,7 +55,7 @@ func TestNewDCmd(t *testing.T) {
 	}
 
 	pieces = []byte{'d', '/', 'd'}
-	c, err = NewCmd(nil, pieces)
+	c, err = NewCmd(new(Sed), pieces)
 	dc = c.(*DCmd)
 	if dc != nil {
 		t.Error("3: Got a command when we shouldn't have " + c.String())
@@ -61,7 +67,7 @@ func TestNewDCmd(t *testing.T) {
 	}
 
 	pieces = []byte{'d'}
-	c, err = NewCmd(nil, pieces)
+	c, err = NewCmd(new(Sed), pieces)
 	dc = c.(*DCmd)
 	if dc == nil {
 		t.Error("Didn't get a d command that we expected")
@@ -70,7 +76,7 @@ func TestNewDCmd(t *testing.T) {
 	}
 
 	pieces = []byte{'$', 'd'}
-	c, err = NewCmd(nil, pieces)
+	c, err = NewCmd(new(Sed), pieces)
 	dc = c.(*DCmd)
 	if dc == nil {
 		t.Error("Didn't get a d command that we expected")
@@ -79,7 +85,7 @@ func TestNewDCmd(t *testing.T) {
 	}
 
 	pieces = []byte{'4', '5', '7', 'd'}
-	c, err = NewCmd(nil, pieces)
+	c, err = NewCmd(new(Sed), pieces)
 	dc = c.(*DCmd)
 	if dc == nil {
 		t.Error("Didn't get a d command that we expected")
@@ -90,7 +96,7 @@ func TestNewDCmd(t *testing.T) {
 
 func TestNewNCmd(t *testing.T) {
 	pieces := []byte{'n', '/', 'o', '/', '0', '/', 'g'}
-	c, err := NewCmd(nil, pieces)
+	c, err := NewCmd(new(Sed), pieces)
 	nc := c.(*NCmd)
 	if nc != nil {
 		t.Error("4: Got a command when we shouldn't have " + c.String())
@@ -105,7 +111,7 @@ func TestNewNCmd(t *testing.T) {
 	}
 
 	pieces = []byte{'n', '/', 'd'}
-	c, err = NewCmd(nil, pieces)
+	c, err = NewCmd(new(Sed), pieces)
 	nc = c.(*NCmd)
 	if nc != nil {
 		t.Error("5: Got a command when we shouldn't have " + c.String())
@@ -117,7 +123,7 @@ func TestNewNCmd(t *testing.T) {
 	}
 
 	pieces = []byte{'n'}
-	c, err = NewCmd(nil, pieces)
+	c, err = NewCmd(new(Sed), pieces)
 	nc = c.(*NCmd)
 	if nc == nil {
 		t.Error("Didn't get a n command that we expected")
@@ -126,7 +132,7 @@ func TestNewNCmd(t *testing.T) {
 	}
 
 	pieces = []byte{'$', 'n'}
-	c, err = NewCmd(nil, pieces)
+	c, err = NewCmd(new(Sed), pieces)
 	nc = c.(*NCmd)
 	if nc == nil {
 		t.Error("Didn't get a d command that we expected")
@@ -135,7 +141,7 @@ func TestNewNCmd(t *testing.T) {
 	}
 
 	pieces = []byte{'4', '5', '7', 'n'}
-	c, err = NewCmd(nil, pieces)
+	c, err = NewCmd(new(Sed), pieces)
 	nc = c.(*NCmd)
 	if nc == nil {
 		t.Error("Didn't get a n command that we expected")
@@ -146,7 +152,7 @@ func TestNewNCmd(t *testing.T) {
 
 func TestNewPCmd(t *testing.T) {
 	pieces := []byte{'P', '/', 'o', '/', '0', '/', 'g'}
-	c, err := NewCmd(nil, pieces)
+	c, err := NewCmd(new(Sed), pieces)
 	pc := c.(*PCmd)
 	if pc != nil {
 		t.Error("6: Got a command when we shouldn't have " + c.String())
@@ -161,7 +167,7 @@ func TestNewPCmd(t *testing.T) {
 	}
 
 	pieces = []byte{'P', '/', 'd'}
-	c, err = NewCmd(nil, pieces)
+	c, err = NewCmd(new(Sed), pieces)
 	pc = c.(*PCmd)
 	if pc != nil {
 		t.Error("7: Got a command when we shouldn't have " + c.String())
@@ -173,7 +179,7 @@ func TestNewPCmd(t *testing.T) {
 	}
 
 	pieces = []byte{'P'}
-	c, err = NewCmd(nil, pieces)
+	c, err = NewCmd(new(Sed), pieces)
 	pc = c.(*PCmd)
 	if pc == nil {
 		t.Error("Didn't get a p command that we expected")
@@ -182,7 +188,7 @@ func TestNewPCmd(t *testing.T) {
 	}
 
 	pieces = []byte{'$', 'P'}
-	c, err = NewCmd(nil, pieces)
+	c, err = NewCmd(new(Sed), pieces)
 	pc = c.(*PCmd)
 	if pc == nil {
 		t.Error("Didn't get a p command that we expected")
@@ -191,7 +197,7 @@ func TestNewPCmd(t *testing.T) {
 	}
 
 	pieces = []byte{'4', '5', '7', 'P'}
-	c, err = NewCmd(nil, pieces)
+	c, err = NewCmd(new(Sed), pieces)
 	pc = c.(*PCmd)
 	if pc == nil {
 		t.Error("Didn't get a p command that we expected")
@@ -202,7 +208,7 @@ func TestNewPCmd(t *testing.T) {
 
 func TestNewQCmd(t *testing.T) {
 	pieces := []byte{'q', '/', 'o', '/', '0', '/', 'g'}
-	c, err := NewCmd(nil, pieces)
+	c, err := NewCmd(new(Sed), pieces)
 	qc := c.(*QCmd)
 	if err == nil {
 		t.Error("Didn't get an error we expected")
@@ -211,7 +217,7 @@ func TestNewQCmd(t *testing.T) {
 	}
 
 	pieces = []byte{'q', '/', 'q'}
-	c, err = NewCmd(nil, pieces)
+	c, err = NewCmd(new(Sed), pieces)
 	qc = c.(*QCmd)
 	if qc != nil {
 		t.Error("9: Got a command when we shouldn't have " + c.String())
@@ -223,7 +229,7 @@ func TestNewQCmd(t *testing.T) {
 	}
 
 	pieces = []byte{'q'}
-	c, err = NewCmd(nil, pieces)
+	c, err = NewCmd(new(Sed), pieces)
 	qc = c.(*QCmd)
 	if qc == nil {
 		t.Error("Didn't get a q command that we expected")
@@ -232,7 +238,7 @@ func TestNewQCmd(t *testing.T) {
 	}
 
 	pieces = []byte{'q', '/', '1'}
-	c, err = NewCmd(nil, pieces)
+	c, err = NewCmd(new(Sed), pieces)
 	qc = c.(*QCmd)
 	if qc == nil {
 		t.Error("Didn't get a q command that we expected")
@@ -241,7 +247,7 @@ func TestNewQCmd(t *testing.T) {
 	}
 
 	pieces = []byte{'$', 'q'}
-	c, err = NewCmd(nil, pieces)
+	c, err = NewCmd(new(Sed), pieces)
 	qc = c.(*QCmd)
 	if qc == nil {
 		t.Error("Didn't get a q command that we expected")
@@ -250,7 +256,7 @@ func TestNewQCmd(t *testing.T) {
 	}
 
 	pieces = []byte{'4', '5', '7', 'q'}
-	c, err = NewCmd(nil, pieces)
+	c, err = NewCmd(new(Sed), pieces)
 	qc = c.(*QCmd)
 	if qc == nil {
 		t.Error("Didn't get a d command that we expected")
@@ -263,7 +269,7 @@ func TestProcessLine(t *testing.T) {
 	_s := new(Sed)
 	_s.Init()
 	pieces := []byte{'s', '/', 'o', '/', '0', '/', 'g'}
-	c, _ := NewCmd(nil, pieces)
+	c, _ := NewCmd(new(Sed), pieces)
 	_s.patternSpace = []byte{'g', 'o', 'o', 'd'}
 	stop, err := c.(Cmd).processLine(_s)
 	if stop {
@@ -275,7 +281,7 @@ func TestProcessLine(t *testing.T) {
 	checkString(t, "bad global s command", "g00d", string(_s.patternSpace))
 
 	pieces = []byte{'s', '/', 'o', '/', '0', '/', '1'}
-	c, _ = NewCmd(nil, pieces)
+	c, _ = NewCmd(new(Sed), pieces)
 	_s.patternSpace = []byte{'g', 'o', 'o', 'd'}
 	stop, err = c.(Cmd).processLine(_s)
 	if stop {
@@ -287,6 +293,99 @@ func TestProcessLine(t *testing.T) {
 	checkString(t, "bad global s command", "g0od", string(_s.patternSpace))
 }
 
+func TestCRLFLineEndings(t *testing.T) {
+	crlf := func(s *Sed) { s.crlf = true }
+	checkString(t, "CRLF endings must round-trip", "a bar\r\nbar\r\nc\n", runSedWith(t, "s/foo$/bar/", "a foo\r\nfoo\r\nc\n", crlf))
+	checkString(t, "missing final newline must round-trip", "x\r\ny", runSedWith(t, "s/b$/y/", "x\r\nb", crlf))
+}
+
+func TestBOM(t *testing.T) {
+	checkString(t, "BOM must not reach the pattern space", "\xEF\xBB\xBFx\nb\n", runSed(t, "1s/^a/x/", "\xEF\xBB\xBFa\nb\n"))
+}
+
+func TestPOSIXMode(t *testing.T) {
+	checkString(t, "N on the last line prints the pattern space", "a-b\nc\n", runSed(t, "N\ns/\\n/-/", "a\nb\nc\n"))
+
+	posix := new(Sed)
+	posix.posix = true
+	for _, script := range []string{"q/1", "2,p", "s/\\t/x/", "/\\d/p", "h:hdr", "s/a+/b/", "/a|b/p", "/x{2}/d", "s/(a)/b/", "s/\\(a\\)/b/", "/a\\{2\\}/d"} {
+		if _, err := NewCmd(posix, []byte(script)); !errors.Is(err, ErrPOSIXExtension) {
+			t.Errorf("%s: expected %v, got %v", script, ErrPOSIXExtension, err)
+		}
+	}
+	for _, script := range []string{"s/a*[+?|(]\\n/b/", "/^x{$/p", "s/a\\.b/c/"} {
+		if _, err := NewCmd(posix, []byte(script)); err != nil {
+			t.Errorf("%s: expected no error, got %v", script, err)
+		}
+	}
+	if _, err := NewCmd(posix, []byte("1,2=")); !errors.Is(err, ErrNoSupportForTwoAddress) {
+		t.Errorf("1,2=: expected %v, got %v", ErrNoSupportForTwoAddress, err)
+	}
+	for script, expected := range map[string]error{"p # c": ErrPOSIXExtension, "# c\np": nil} {
+		s := new(Sed)
+		s.Init()
+		s.posix = true
+		if err := s.parseScript([]byte(script)); !errors.Is(err, expected) {
+			t.Errorf("%q: expected %v, got %v", script, expected, err)
+		}
+	}
+	checkString(t, "N on the last line quits without printing", "a-b\n", runSedWith(t, "N\ns/\\n/-/", "a\nb\nc\n", func(s *Sed) { s.posix = true }))
+}
+
+func TestSource(t *testing.T) {
+	for _, script := range []string{"s/o/0/g", "s/a/b/2", "3,$p", "/x/!d", "$D", "2,5G", "q 3", "1x", "=", "b end", "N", "P", "H", "x:acc", "2G:hdr", "/a/,/b/p", "2,/x/!d", "$,/x/p"} {
+		c, err := NewCmd(new(Sed), []byte(script))
+		if err != nil {
+			t.Errorf("Got an error we didn't expect for %s: %v", script, err)
+			continue
+		}
+		checkString(t, "canonical form", script, c.source())
+	}
+	checkString(t, "canonical form of 5,", "5,$p", mustCmd(t, "5,p").source())
+	checkString(t, "canonical form of q5", "q 5", mustCmd(t, "q5").source())
+}
+
+func mustCmd(t *testing.T, script string) Cmd {
+	c, err := NewCmd(new(Sed), []byte(script))
+	if err != nil {
+		t.Fatalf("Got an error we didn't expect for %s: %v", script, err)
+	}
+	return c
+}
+
+// runSed runs script over input and returns everything written to the output.
+func runSed(t *testing.T, script, input string) string {
+	return runSedWith(t, script, input, nil)
+}
+
+// runSedWith is runSed with a chance to set up the Sed once the script is parsed.
+func runSedWith(t *testing.T, script, input string, setup func(s *Sed)) string {
+	s := new(Sed)
+	s.Init()
+	if err := s.parseScript([]byte(script)); err != nil {
+		t.Fatalf("Got an error parsing %q: %v", script, err)
+	}
+	if setup != nil {
+		setup(s)
+	}
+	out, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	s.setOutput(out)
+	s.input = bufio.NewReader(strings.NewReader(input))
+	if err := s.runInput(); err != nil {
+		t.Fatalf("Got an error running %q: %v", script, err)
+	}
+	out.Seek(0, 0)
+	b, err := io.ReadAll(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
 func checkInt(t *testing.T, val, expected int, actual string) {
 	if expected != val {
 		t.Errorf("%d: '%d' != '%s'", val, expected, actual)
@@ -298,3 +397,135 @@ func checkString(t *testing.T, message, expected, actual string) {
 		t.Errorf("%s: '%s' != '%s'", message, expected, actual)
 	}
 }
+
+func TestBlocksAndBranches(t *testing.T) {
+	checkString(t, "a block runs only on the lines its address matches", "a\nb\nb\nc\n", runSed(t, "/b/{p;}", "a\nb\nc\n"))
+	checkString(t, "nested blocks", "b\n", runSed(t, "#n\n/b/{\n  /b/{p}\n}", "a\nb\nc\n"))
+	checkString(t, "a branch to a label skips what is in between", "a\nb\n", runSed(t, "b skip\ns/./x/\n:skip", "a\nb\n"))
+	checkString(t, "a branch without a label ends the cycle", "a\nx\n", runSed(t, "/a/b\ns/./x/", "a\nb\n"))
+	checkString(t, "a text is written at the end of the cycle", "a\nafter\nb\n", runSed(t, "1a\\\nafter", "a\nb\n"))
+	checkString(t, "a text is written even when the cycle is cut short", "after\nb\n", runSed(t, "1{a\\\nafter\nd}", "a\nb\n"))
+
+	for script, expected := range map[string]error{
+		"{p":      ErrUnmatchedBrace,
+		"p}":      ErrUnexpectedBrace,
+		"b nope":  ErrUndefinedLabel,
+		":a\n:a":  ErrDuplicateLabel,
+		"p x":     ErrExtraCharacters,
+		"1,2}":    ErrNoAddressAllowed,
+		"2:label": ErrNoAddressAllowed,
+	} {
+		s := new(Sed)
+		s.Init()
+		err := s.parseScript([]byte(script))
+		var scriptErr *ScriptError
+		if !errors.Is(err, expected) || !errors.As(err, &scriptErr) {
+			t.Errorf("%q: expected a script error wrapping %v, got %v", script, expected, err)
+		}
+	}
+}
+
+func TestTAndYCommands(t *testing.T) {
+	checkString(t, "t loops while s replaces something", "hexxo\n", runSed(t, ":a\ns/l/x/\nta", "hello\n"))
+	checkString(t, "t doesn't branch without a substitution", "b\n", runSed(t, "s/x/y/\nt\ns/a/b/", "a\n"))
+	checkString(t, "y transliterates", "HELLO\n", runSed(t, "y/ehlo/EHLO/", "hello\n"))
+	checkString(t, "y with \\n", "a b\n", runSed(t, "N\ny/\\n/ /", "a\nb\n"))
+	if _, err := NewCmd(new(Sed), []byte("y/abc/xy/")); !errors.Is(err, ErrYLengthMismatch) {
+		t.Errorf("Expected %v, got %v", ErrYLengthMismatch, err)
+	}
+}
+
+func TestHoldReadChangeAndRestart(t *testing.T) {
+	checkString(t, "H appends to the hold space", "\na\nb\n", runSed(t, "#n\nH\n2{\n    x\n    p\n}", "a\nb\n"))
+	checkString(t, "named registers are kept apart from the hold space", "a1 in A\na2 in A\nb1 in B\n", runSed(t, "/^[A-Z]/{\n    h:hdr\n    d\n}\nG:hdr\ns/\\n/ in /", "A\na1\na2\nB\nb1\n"))
+	checkString(t, "x swaps with a named register", "\na\nb\n", runSed(t, "x:r\n$G:r", "a\nb\n"))
+	checkString(t, "H appends to a named register", "\na\nb\n", runSed(t, "H:acc\n$!d\nx:acc", "a\nb\n"))
+	checkString(t, "c on a range prints its text once", "a\nX\nd\n", runSed(t, "2,3c\\\nX", "a\nb\nc\nd\n"))
+	checkString(t, "D restarts the cycle with what is left", "a\nb\nc\n", runSed(t, "N\nP\nD", "a\nb\nc\n"))
+	unbuffered := func(s *Sed) { s.unbuffered = true }
+	checkString(t, "-u writes the same output", "a\na\nb\nb", runSedWith(t, "p", "a\nb", unbuffered))
+	checkString(t, "s numbers the right match", "fo0 xoo\n", runSed(t, "s/o/0/2", "foo xoo\n"))
+	checkString(t, "s expands groups", "b-a\n", runSed(t, "s/(a)-(b)/${2}-${1}/g", "a-b\n"))
+	checkString(t, "y keeps bytes that aren't UTF-8", "A\xffB\n", runSed(t, "y/ab/AB/", "a\xffb\n"))
+	checkString(t, "$ matches the last line", "a\nb\nc\nc\n", runSed(t, "$p", "a\nb\nc\n"))
+	checkString(t, "$! matches every other line", "a-b\nc\n", runSed(t, "$!N\ns/\\n/-/", "a\nb\nc\n"))
+	checkString(t, "a span runs from its first address to its last", "a\na\na\nc\n", runSed(t, "/b/,/c/d", "a\nb\nc\na\nb\nb\nc\na\nc\n"))
+	checkString(t, "a span ending on a line already passed is one line long", "a\nb\nd\n", runSed(t, "/c/,2d", "a\nb\nc\nd\n"))
+
+	file := filepath.Join(t.TempDir(), "r.txt")
+	if err := os.WriteFile(file, []byte("from file\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	checkString(t, "r copies the file at the end of the cycle", "a\nfrom file\nb\n", runSed(t, "1r "+file+"\ns/x/y/", "a\nb\n"))
+	checkString(t, "r ignores a missing file", "a\n", runSed(t, "r "+file+".missing", "a\n"))
+}
+
+// runSedOnFiles runs script over files holding contents, given as operands without -i.
+func runSedOnFiles(t *testing.T, script string, contents ...string) string {
+	dir := t.TempDir()
+	var names []string
+	for i, content := range contents {
+		name := filepath.Join(dir, string(rune('a'+i)))
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	var out strings.Builder
+	s := new(Sed)
+	s.Init()
+	if err := s.parseScript([]byte(script)); err != nil {
+		t.Fatalf("Got an error parsing %q: %v", script, err)
+	}
+	s.output.Reset(&out)
+	s.setInputFiles(names)
+	for len(s.nextInputs) > 0 && !s.quit {
+		if err := s.nextInput(); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.runInput(); err != nil {
+			t.Fatalf("Got an error running %q: %v", script, err)
+		}
+		s.closeInput()
+	}
+	return out.String()
+}
+
+func TestSeveralInputFiles(t *testing.T) {
+	checkString(t, "n goes on into the next file", "1\n2\n3\n4\n5\n", runSedOnFiles(t, "n", "1\n2\n3\n", "4\n5\n"))
+	checkString(t, "N joins lines across files", "1-2\n3-4\n5\n", runSedOnFiles(t, "N;s/\\n/-/", "1\n2\n3\n", "4\n5\n"))
+	checkString(t, "N skips empty files", "1-2\n", runSedOnFiles(t, "N;s/\\n/-/", "1\n", "", "2\n"))
+	checkString(t, "line numbers carry on", "1\n2\n3\n", runSedOnFiles(t, "=;d", "a\n", "b\nc\n"))
+}
+
+func TestLastLineOfSeveralFiles(t *testing.T) {
+	checkString(t, "$ is the last line of the last file", "5\n", runSedOnFiles(t, "$!d", "1\n2\n3\n", "4\n5\n"))
+	checkString(t, "$ looks past empty files", "1\n", runSedOnFiles(t, "$!d", "1\n", ""))
+	checkString(t, "a missing line ending before the next file", "x\n4\n", runSedOnFiles(t, "p;d", "x", "4\n"))
+
+	// With -i every file has its own last line
+	saved := *editInplace
+	*editInplace = inPlaceFlag{enabled: true}
+	defer func() { *editInplace = saved }()
+	s := new(Sed)
+	s.Init()
+	if err := s.parseScript([]byte("$s/^/last /")); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for i, content := range []string{"1\n2\n", "3\n"} {
+		name := filepath.Join(dir, string(rune('a'+i)))
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		temp, err := s.editToTemp(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		edited, err := os.ReadFile(temp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkString(t, "-i", []string{"1\nlast 2\n", "last 3\n"}[i], string(edited))
+	}
+}
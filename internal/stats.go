@@ -0,0 +1,233 @@
+// stats.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+
+// Package sed implements the entire program, from this specific part, we implement --stats and --report=json, which tell what a script did to its input
+package sed
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrUnknownReportFormat is returned for a --report other than json.
+var ErrUnknownReportFormat = errors.New("Unknown report format, the only one is json")
+
+// runStats is what a run of the script did, counted as it runs by command and by input file, as one of the
+// observers of the Sed.
+type runStats struct {
+	NopObserver
+	observerHooks
+	Files         []*fileStats    `json:"files"`
+	Commands      []*commandStats `json:"commands"`
+	LinesRead     int             `json:"lines_read"`
+	Substitutions int             `json:"substitutions"`
+	LinesDeleted  int             `json:"lines_deleted"`
+	LinesInserted int             `json:"lines_inserted"`
+	FilesChanged  int             `json:"files_changed"`
+
+	byIndex []*commandStats // the stats of each instruction of the program, nil for those that do nothing
+	file    fileStats       // the counters when the current file started
+	before  statsMark       // the counters before the command that runs
+}
+
+// commandStats is what one command of the script did.
+type commandStats struct {
+	Command       string `json:"command"`
+	Line          int    `json:"line"`
+	Matched       int    `json:"matched"` // the cycles the command ran in
+	Substitutions int    `json:"substitutions"`
+	LinesDeleted  int    `json:"lines_deleted"`
+	LinesInserted int    `json:"lines_inserted"`
+}
+
+// fileStats is what the script did to one input file. Changed is only known for the files of -i.
+type fileStats struct {
+	Name          string `json:"name"`
+	LinesRead     int    `json:"lines_read"`
+	Substitutions int    `json:"substitutions"`
+	Changed       *bool  `json:"changed,omitempty"`
+}
+
+// statsMark is where the counters of a Sed stood before a command ran.
+type statsMark struct {
+	replacements, linesWritten, queued int
+}
+
+// newRunStats creates the stats of a run of s's program.
+func newRunStats(s *Sed) *runStats {
+	st := &runStats{byIndex: make([]*commandStats, len(s.program))}
+	for i, in := range s.program {
+		switch in.cmd.(type) {
+		case *BlockEndCmd, *LabelCmd:
+			continue
+		}
+		c := &commandStats{Command: in.cmd.source(), Line: s.scriptPositions[in.cmd].line}
+		st.byIndex[i] = c
+		st.Commands = append(st.Commands, c)
+	}
+	return st
+}
+
+// Command marks where the counters stand before a command runs.
+func (st *runStats) Command(ctx *ExecContext, _ CommandInfo) {
+	s := ctx.s
+	st.before = statsMark{s.replacements, s.linesWritten, len(s.appendQueue)}
+}
+
+// ran counts what the command of the instruction pc did, from the counters before it ran.
+func (st *runStats) ran(ctx *ExecContext, pc int, cmd Cmd, _ bool) {
+	c := st.byIndex[pc]
+	if c == nil {
+		return
+	}
+	s, before := ctx.s, st.before
+	c.Matched++
+	c.Substitutions += s.replacements - before.replacements
+	switch cmd := cmd.(type) {
+	case *DCmd:
+		c.LinesDeleted++
+	case *CCmd:
+		c.LinesDeleted++
+		if s.linesWritten > before.linesWritten {
+			c.LinesInserted += textLines(cmd.text)
+		}
+	case *ICmd:
+		c.LinesInserted += textLines(cmd.text)
+	case *ACmd, *RCmd:
+		for _, text := range s.appendQueue[before.queued:] {
+			c.LinesInserted += textLines(text)
+		}
+	}
+}
+
+// textLines is the number of lines text is written as.
+func textLines(text []byte) int {
+	return 1 + bytes.Count(text, newLine)
+}
+
+// beginFile starts counting for the input file name.
+func (st *runStats) beginFile(ctx *ExecContext, name string) {
+	s := ctx.s
+	st.file = fileStats{Name: name, LinesRead: s.linesRead, Substitutions: s.replacements}
+}
+
+// endFile adds what the script did to the current file since beginFile.
+func (st *runStats) endFile(ctx *ExecContext) error {
+	s := ctx.s
+	file := &fileStats{
+		Name:          st.file.Name,
+		LinesRead:     s.linesRead - st.file.LinesRead,
+		Substitutions: s.replacements - st.file.Substitutions,
+	}
+	st.Files = append(st.Files, file)
+	st.LinesRead += file.LinesRead
+	st.Substitutions += file.Substitutions
+	return nil
+}
+
+// changed records whether -i changed the last file.
+func (st *runStats) changed(changed bool) {
+	if len(st.Files) == 0 {
+		return
+	}
+	st.Files[len(st.Files)-1].Changed = &changed
+	if changed {
+		st.FilesChanged++
+	}
+}
+
+// merge adds the stats of another run of the same program, a worker of -j, after the files of st.
+func (st *runStats) merge(other *runStats) {
+	for i, c := range other.byIndex {
+		if c != nil {
+			st.byIndex[i].Matched += c.Matched
+			st.byIndex[i].Substitutions += c.Substitutions
+			st.byIndex[i].LinesDeleted += c.LinesDeleted
+			st.byIndex[i].LinesInserted += c.LinesInserted
+		}
+	}
+	st.Files = append(st.Files, other.Files...)
+	st.LinesRead += other.LinesRead
+	st.Substitutions += other.Substitutions
+}
+
+// write writes the stats to w, as JSON when format is "json", as a summary for people otherwise.
+func (st *runStats) write(w io.Writer, format string) error {
+	st.LinesDeleted, st.LinesInserted = 0, 0
+	for _, c := range st.Commands {
+		st.LinesDeleted += c.LinesDeleted
+		st.LinesInserted += c.LinesInserted
+	}
+	if format == "json" {
+		out, err := json.MarshalIndent(st, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", out)
+		return err
+	}
+	fmt.Fprintf(w, "%d files, %d lines read, %d substitutions, %d lines deleted, %d lines inserted", len(st.Files), st.LinesRead, st.Substitutions, st.LinesDeleted, st.LinesInserted)
+	if editInplace.enabled {
+		fmt.Fprintf(w, ", %d files changed", st.FilesChanged)
+	}
+	fmt.Fprintln(w)
+	for _, c := range st.Commands {
+		fmt.Fprintf(w, "  line %d: %s: matched %d", c.Line, c.Command, c.Matched)
+		for _, count := range []struct {
+			n    int
+			what string
+		}{{c.Substitutions, "substitutions"}, {c.LinesDeleted, "deleted"}, {c.LinesInserted, "inserted"}} {
+			if count.n > 0 {
+				fmt.Fprintf(w, ", %s %d", count.what, count.n)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	for _, file := range st.Files {
+		fmt.Fprintf(w, "  %s: %d lines, %d substitutions", file.Name, file.LinesRead, file.Substitutions)
+		if file.Changed != nil && *file.Changed {
+			fmt.Fprint(w, ", changed")
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// sameContents reports whether the files a and b hold the same bytes.
+func sameContents(a, b string) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+	bufA, bufB := make([]byte, 64*1024), make([]byte, 64*1024)
+	for {
+		na, errA := io.ReadFull(fa, bufA)
+		nb, errB := io.ReadFull(fb, bufB)
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+		if errA != nil || errB != nil {
+			if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+				errA = nil
+			}
+			if errB == io.EOF || errB == io.ErrUnexpectedEOF {
+				errB = nil
+			}
+			return true, errors.Join(errA, errB)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// stats_test.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+package sed
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	var st *runStats
+	output := runSedWith(t, "s/a/A/g\n/x/d\n/y/a\\\none\\\ntwo\n/z/c\\\nZ\n/b/s/b/B/2", "aa\nx\ny a\nz\nbbb\n", func(s *Sed) {
+		s.setStats(newRunStats(s))
+		st = s.stats
+		s.beginFile("input")
+	})
+	checkString(t, "output", "AA\ny A\none\ntwo\nZ\nbBb\n", output)
+
+	var summary bytes.Buffer
+	st.write(&summary, "")
+	for _, expected := range []string{
+		"line 1: s/a/A/g: matched 5, substitutions 3\n",
+		"line 2: /x/d: matched 1, deleted 1\n",
+		"line 3: /y/a\\\n",
+		"matched 1, inserted 2\n",
+		"line 6: /z/c\\\n",
+		"matched 1, deleted 1, inserted 1\n",
+		"line 8: /b/s/b/B/2: matched 1, substitutions 1\n",
+	} {
+		if !strings.Contains(summary.String(), expected) {
+			t.Errorf("Expected %q in the summary:\n%s", expected, summary.String())
+		}
+	}
+}
+
+func TestStatsOfM(t *testing.T) {
+	table := filepath.Join(t.TempDir(), "map.tsv")
+	if err := os.WriteFile(table, []byte("a\tA\nbc\tBC\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var sed *Sed
+	runSedWith(t, "m "+table, "abc a\nx\n", func(s *Sed) {
+		s.setStats(newRunStats(s))
+		s.beginFile("input")
+		sed = s
+	})
+	if err := sed.endFile(); err != nil {
+		t.Fatal(err)
+	}
+	var summary bytes.Buffer
+	sed.stats.write(&summary, "")
+	for _, expected := range []string{
+		"1 files, 2 lines read, 3 substitutions",
+		"line 1: m " + table + ": matched 2, substitutions 3\n",
+		"input: 2 lines, 3 substitutions\n",
+	} {
+		if !strings.Contains(summary.String(), expected) {
+			t.Errorf("Expected %q in the summary:\n%s", expected, summary.String())
+		}
+	}
+}
+
+func TestStatsReport(t *testing.T) {
+	saved := *editInplace
+	*editInplace = inPlaceFlag{enabled: true}
+	defer func() { *editInplace = saved }()
+
+	dir := t.TempDir()
+	var names []string
+	for i, content := range []string{"old\nold old\n", "new\n", "old\n"} {
+		name := filepath.Join(dir, string(rune('a'+i)))
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	s := new(Sed)
+	s.Init()
+	if err := s.parseScript([]byte("s/old/new/g")); err != nil {
+		t.Fatal(err)
+	}
+	s.setStats(newRunStats(s))
+	commit := func(name, temp string) error {
+		same, err := sameContents(name, temp)
+		if err != nil {
+			return err
+		}
+		s.stats.changed(!same)
+		return commitEdit(name, temp)
+	}
+	if _, err := s.editInParallel(names, 2, commit); err != nil {
+		t.Fatal(err)
+	}
+
+	var report bytes.Buffer
+	if err := s.stats.write(&report, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var parsed struct {
+		Files []struct {
+			Name          string
+			Substitutions int
+			Changed       bool
+		}
+		Commands []struct {
+			Command string
+			Matched int
+		}
+		Substitutions int
+		FilesChanged  int `json:"files_changed"`
+		LinesRead     int `json:"lines_read"`
+	}
+	if err := json.Unmarshal(report.Bytes(), &parsed); err != nil {
+		t.Fatalf("Bad JSON report: %v\n%s", err, report.String())
+	}
+	if parsed.Substitutions != 4 || parsed.FilesChanged != 2 || parsed.LinesRead != 4 || len(parsed.Files) != 3 {
+		t.Errorf("Bad totals in the report:\n%s", report.String())
+	}
+	for i, changed := range []bool{true, false, true} {
+		if parsed.Files[i].Name != names[i] || parsed.Files[i].Changed != changed {
+			t.Errorf("Bad file %d in the report:\n%s", i, report.String())
+		}
+	}
+	if len(parsed.Commands) != 1 || parsed.Commands[0].Command != "s/old/new/g" || parsed.Commands[0].Matched != 4 {
+		t.Errorf("Bad commands in the report:\n%s", report.String())
+	}
+}
@@ -0,0 +1,234 @@
+// step.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+
+// Package sed implements the entire program, from this specific part, we implement --step, an interactive debugger for scripts
+package sed
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const stepperHelp = `Commands:
+  s, step              run the next command and stop before the one after it
+  c, continue          run until the next breakpoint
+  b, break N           stop before the command on script line N
+  b, break input N     stop when input line N starts a cycle
+  b, break addr ADDR   stop when a cycle starts on a line ADDR matches, e.g. break addr /foo/
+  d, delete            remove every breakpoint
+  i, info              list the breakpoints
+  l, list              list the program, marking the next command
+  r, ranges            list the range addresses the current line is in
+  p, pattern           print the pattern space
+  h, hold [NAME]       print the hold space, or the named hold register NAME
+  set pattern TEXT     replace the pattern space, \n in TEXT is a newline
+  set hold TEXT        replace the hold space, \n in TEXT is a newline
+  q, quit              stop the script without printing anything else
+  help                 show this help
+`
+
+// stepper is the interactive debugger behind --step. It pauses before commands and reads what to do from the terminal,
+// so the input of the script can still come from stdin.
+type stepper struct {
+	in          *bufio.Scanner
+	out         io.Writer
+	stepping    bool         // pause before the next command, whatever the breakpoints say
+	scriptLines map[int]bool // breakpoints on script line numbers
+	inputLines  map[int]bool // breakpoints on input line numbers
+	addresses   []*address   // breakpoints on addresses
+}
+
+// newStepper creates a stepper reading commands from in and talking on out. It starts by pausing before the first command.
+func newStepper(in io.Reader, out io.Writer) *stepper {
+	return &stepper{
+		in:          bufio.NewScanner(in),
+		out:         out,
+		stepping:    true,
+		scriptLines: make(map[int]bool),
+		inputLines:  make(map[int]bool),
+	}
+}
+
+// openStepper creates the stepper for --step on the terminal.
+func openStepper() (*stepper, error) {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return nil, fmt.Errorf("--step needs a terminal: %w", err)
+	}
+	return newStepper(tty, os.Stderr), nil
+}
+
+// startCycle is called once a new line is in the pattern space, and arms the breakpoints on input lines and addresses.
+func (st *stepper) startCycle(s *Sed) {
+	if st.inputLines[s.lineNumber] {
+		fmt.Fprintf(st.out, "Breakpoint: input line %d\n", s.lineNumber)
+		st.stepping = true
+	}
+	for _, addr := range st.addresses {
+		if addr.matches(s) {
+			fmt.Fprintf(st.out, "Breakpoint: address %s on input line %d\n", addr.source(), s.lineNumber)
+			st.stepping = true
+		}
+	}
+}
+
+// beforeCommand is called before c runs. When it has to pause, it reads commands until the user lets the script go on.
+// It returns false if the user quit.
+func (st *stepper) beforeCommand(s *Sed, c Cmd) bool {
+	line := s.scriptPositions[c].line
+	if st.scriptLines[line] {
+		fmt.Fprintf(st.out, "Breakpoint: script line %d\n", line)
+		st.stepping = true
+	}
+	if !st.stepping {
+		return true
+	}
+	fmt.Fprintf(st.out, "input line %d, script line %d: %s\n", s.lineNumber, line, c.source())
+	for {
+		fmt.Fprint(st.out, "(sed) ")
+		if !st.in.Scan() {
+			// The terminal went away, let the script finish on its own
+			st.stepping = false
+			return true
+		}
+		verb, arg, _ := strings.Cut(strings.TrimSpace(st.in.Text()), " ")
+		arg = strings.TrimSpace(arg)
+		switch verb {
+		case "", "s", "step":
+			return true
+		case "c", "continue":
+			st.stepping = false
+			return true
+		case "q", "quit":
+			return false
+		case "b", "break":
+			st.addBreakpoint(s, arg)
+		case "d", "delete":
+			st.scriptLines = make(map[int]bool)
+			st.inputLines = make(map[int]bool)
+			st.addresses = nil
+		case "i", "info":
+			st.listBreakpoints()
+		case "l", "list":
+			st.listProgram(s, c)
+		case "r", "ranges":
+			st.listRanges(s)
+		case "p", "pattern":
+			fmt.Fprintf(st.out, "%s\n", debugEscape(s.patternSpace))
+		case "h", "hold":
+			fmt.Fprintf(st.out, "%s\n", debugEscape(s.hold(arg)))
+		case "set":
+			space, text, _ := strings.Cut(arg, " ")
+			value := []byte(strings.ReplaceAll(text, "\\n", "\n"))
+			switch space {
+			case "pattern":
+				s.patternSpace = value
+			case "hold":
+				s.holdSpace = value
+			default:
+				fmt.Fprintln(st.out, "Usage: set pattern|hold TEXT")
+			}
+		case "help":
+			fmt.Fprint(st.out, stepperHelp)
+		default:
+			fmt.Fprintf(st.out, "Unknown command %q, try help\n", verb)
+		}
+	}
+}
+
+// addBreakpoint parses the argument of the break command.
+func (st *stepper) addBreakpoint(s *Sed, arg string) {
+	kind, value, _ := strings.Cut(arg, " ")
+	switch kind {
+	case "input":
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			fmt.Fprintf(st.out, "Bad input line number: %s\n", value)
+			return
+		}
+		st.inputLines[n] = true
+	case "addr":
+		rest, addr, err := checkForAddress(s, []byte(strings.TrimSpace(value)))
+		if err == nil && (addr == nil || len(bytes.TrimSpace(rest)) != 0) {
+			err = fmt.Errorf("not an address: %s", value)
+		}
+		if err != nil {
+			fmt.Fprintf(st.out, "Bad address: %v\n", err)
+			return
+		}
+		st.addresses = append(st.addresses, addr)
+	default:
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			fmt.Fprintln(st.out, "Usage: break N | break input N | break addr ADDR")
+			return
+		}
+		st.scriptLines[n] = true
+	}
+}
+
+func (st *stepper) listBreakpoints() {
+	for _, line := range sortedKeys(st.scriptLines) {
+		fmt.Fprintf(st.out, "script line %d\n", line)
+	}
+	for _, line := range sortedKeys(st.inputLines) {
+		fmt.Fprintf(st.out, "input line %d\n", line)
+	}
+	for _, addr := range st.addresses {
+		fmt.Fprintf(st.out, "address %s\n", addr.source())
+	}
+}
+
+func sortedKeys(m map[int]bool) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// listProgram prints the script with its line numbers, marking the command about to run.
+func (st *stepper) listProgram(s *Sed, next Cmd) {
+	for _, in := range s.program {
+		c := in.cmd
+		marker := " "
+		if c == next {
+			marker = ">"
+		}
+		fmt.Fprintf(st.out, "%s %4d  %s\n", marker, s.scriptPositions[c].line, strings.ReplaceAll(c.source(), "\n", "\n        "))
+	}
+}
+
+// listRanges prints the commands with a range address the current input line is in.
+func (st *stepper) listRanges(s *Sed) {
+	found := false
+	for _, in := range s.program {
+		c := in.cmd
+		addr := c.getAddress()
+		if !addr.isRange() {
+			continue
+		}
+		in := addr.match(s.patternSpace, s.lineNumber)
+		if addr.addressType == addressSpan {
+			in = s.spans[addr]
+		}
+		if in != addr.not {
+			fmt.Fprintf(st.out, "script line %d: %s\n", s.scriptPositions[c].line, c.source())
+			found = true
+		}
+	}
+	if !found {
+		fmt.Fprintln(st.out, "No active range")
+	}
+}
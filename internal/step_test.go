@@ -0,0 +1,27 @@
+// step_test.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+package sed
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestStepper(t *testing.T) {
+	var out bytes.Buffer
+	commands := "break 3\ncontinue\nset pattern Z\npattern\nranges\ncontinue\nbreak addr /d/\ncontinue\nlist\nquit\n"
+	output := runSedWith(t, "s/a/b/\n1,2h\nx", "a\nc\nd\n", func(s *Sed) {
+		s.stepper = newStepper(strings.NewReader(commands), &out)
+	})
+	checkString(t, "output", "b\nc\n", output)
+	for _, expected := range []string{"Breakpoint: script line 3", "(sed) Z\n", "script line 2: 1,2h", "Breakpoint: address /d/ on input line 3", ">    1  s/a/b/\n     2  1,2h"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %q in the stepper's output:\n%s", expected, out.String())
+		}
+	}
+}
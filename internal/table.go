@@ -0,0 +1,164 @@
+// table.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+
+// Package sed implements the entire program, from this specific part, we implement the replacement tables of the m command, which replace any number of literals in a single pass with an Aho-Corasick automaton
+package sed
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// replaceTable replaces every occurrence of a set of literals with their replacements in a single pass over the
+// text. Of the matches starting at the same place the longest wins, and the text is scanned from left to right,
+// like POSIX regexes do.
+type replaceTable struct {
+	from, to [][]byte
+	nodes    []tableNode // the trie of every from, nodes[0] being the root
+	root     [256]int32  // the transitions out of the root, kept apart since every byte is looked up there
+}
+
+// tableNode is a node of the automaton, standing for the text on the way to it from the root.
+type tableNode struct {
+	edges []tableEdge
+	fail  int32 // the node of the longest proper suffix of the node's text that is in the trie
+	match int32 // the pair whose from is the longest suffix of the node's text, -1 when there is none
+	depth int32 // the length of the node's text
+}
+
+type tableEdge struct {
+	b    byte
+	node int32
+}
+
+// child returns the node b leads to from n, -1 when it leads nowhere.
+func (n *tableNode) child(b byte) int32 {
+	for _, e := range n.edges {
+		if e.b == b {
+			return e.node
+		}
+	}
+	return -1
+}
+
+// loadReplaceTable reads a table from a file of old<TAB>new lines. Empty lines are skipped, and when the same old
+// text is given twice the later line wins.
+func loadReplaceTable(name string) (*replaceTable, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var from, to [][]byte
+	for n, line := range bytes.Split(data, newLine) {
+		line = bytes.TrimSuffix(line, []byte{'\r'})
+		if len(line) == 0 {
+			continue
+		}
+		old, replacement, ok := bytes.Cut(line, []byte{'\t'})
+		if !ok || len(old) == 0 {
+			return nil, fmt.Errorf("%w: %s:%d", ErrReplaceTableLine, name, n+1)
+		}
+		from = append(from, old)
+		to = append(to, replacement)
+	}
+	return newReplaceTable(from, to), nil
+}
+
+// newReplaceTable builds the automaton replacing each from[i] with to[i].
+func newReplaceTable(from, to [][]byte) *replaceTable {
+	t := &replaceTable{from: from, to: to, nodes: []tableNode{{match: -1}}}
+	for i, old := range from {
+		node := int32(0)
+		for _, b := range old {
+			next := t.nodes[node].child(b)
+			if next < 0 {
+				next = int32(len(t.nodes))
+				t.nodes = append(t.nodes, tableNode{match: -1, depth: t.nodes[node].depth + 1})
+				t.nodes[node].edges = append(t.nodes[node].edges, tableEdge{b, next})
+			}
+			node = next
+		}
+		t.nodes[node].match = int32(i)
+	}
+
+	// The failure links, breadth first so that the links of shorter texts are there when longer ones need them
+	queue := []int32{0}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, e := range t.nodes[node].edges {
+			child := &t.nodes[e.node]
+			if node != 0 {
+				fail := t.nodes[node].fail
+				for fail != 0 && t.nodes[fail].child(e.b) < 0 {
+					fail = t.nodes[fail].fail
+				}
+				if next := t.nodes[fail].child(e.b); next >= 0 {
+					child.fail = next
+				}
+			}
+			if child.match < 0 {
+				child.match = t.nodes[child.fail].match
+			}
+			queue = append(queue, e.node)
+		}
+	}
+	for _, e := range t.nodes[0].edges {
+		t.root[e.b] = e.node
+	}
+	return t
+}
+
+// step returns the node the automaton goes to from node on reading b.
+func (t *replaceTable) step(node int32, b byte) int32 {
+	for node != 0 {
+		if next := t.nodes[node].child(b); next >= 0 {
+			return next
+		}
+		node = t.nodes[node].fail
+	}
+	return t.root[b]
+}
+
+// replace appends text to dst with the replacements made, and returns how many there were. Without any, dst is
+// returned as it was. each, when not nil, is called for every replacement with where the old text is in text.
+func (t *replaceTable) replace(dst, text []byte, each func(start int, old, replacement []byte)) ([]byte, int) {
+	replaced := 0
+	done := 0 // text up to here is in dst
+	for pos := done; pos < len(text); pos = done {
+		// Find the leftmost match, then let it grow while a longer one starting at the same place is possible
+		start, end, pair := 0, 0, int32(-1)
+		node := int32(0)
+		for i := pos; i < len(text); i++ {
+			node = t.step(node, text[i])
+			if m := t.nodes[node].match; m >= 0 {
+				if s := i + 1 - len(t.from[m]); pair < 0 || s <= start {
+					start, end, pair = s, i+1, m
+				}
+			}
+			if pair >= 0 && i+1-int(t.nodes[node].depth) > start {
+				// Whatever matches from here on starts after the match found
+				break
+			}
+		}
+		if pair < 0 {
+			break
+		}
+		dst = append(dst, text[done:start]...)
+		dst = append(dst, t.to[pair]...)
+		if each != nil {
+			each(start, text[start:end], t.to[pair])
+		}
+		done = end
+		replaced++
+	}
+	if replaced == 0 {
+		return dst, 0
+	}
+	return append(dst, text[done:]...), replaced
+}
@@ -0,0 +1,123 @@
+// table_test.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+package sed
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// replaceSlowly is what replaceTable does, done the obvious way: at every position the longest old text
+// starting there is replaced, the later pair winning between equal ones.
+func replaceSlowly(from, to []string, text string) string {
+	out := ""
+	for i := 0; i < len(text); {
+		best := -1
+		for j, old := range from {
+			if len(text)-i >= len(old) && text[i:i+len(old)] == old && (best < 0 || len(old) >= len(from[best])) {
+				best = j
+			}
+		}
+		if best < 0 {
+			out += text[i : i+1]
+			i++
+			continue
+		}
+		out += to[best]
+		i += len(from[best])
+	}
+	return out
+}
+
+func TestReplaceTable(t *testing.T) {
+	tables := [][]string{
+		{"foo", "foobar", "ba", "o"},
+		{"abcd", "bc", "c"},
+		{"he", "she", "his", "hers"},
+		{"a", "aa", "aaa"},
+		{"x", "x"},
+	}
+	texts := []string{"", "foobar foo baz", "abcd abc bcd cd", "ushers his hers", "aaaaaaa", "xxy", "none here"}
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		text := make([]byte, random.Intn(30))
+		for j := range text {
+			text[j] = "abcd"[random.Intn(4)]
+		}
+		texts = append(texts, string(text))
+	}
+	for n, from := range tables {
+		to := make([]string, len(from))
+		var fromBytes, toBytes [][]byte
+		for i := range from {
+			to[i] = "<" + string(rune('A'+n)) + string(rune('0'+i)) + ">"
+			fromBytes = append(fromBytes, []byte(from[i]))
+			toBytes = append(toBytes, []byte(to[i]))
+		}
+		table := newReplaceTable(fromBytes, toBytes)
+		for _, text := range texts {
+			out, replaced := table.replace(nil, []byte(text), nil)
+			if replaced == 0 {
+				out = []byte(text)
+			}
+			checkString(t, "replacing "+text, replaceSlowly(from, to, text), string(out))
+		}
+	}
+	random = rand.New(rand.NewSource(2))
+	for i := 0; i < 100; i++ {
+		var from, to []string
+		var fromBytes, toBytes [][]byte
+		for j := random.Intn(6) + 1; j > 0; j-- {
+			old := make([]byte, random.Intn(4)+1)
+			for k := range old {
+				old[k] = "abc"[random.Intn(3)]
+			}
+			from = append(from, string(old))
+			to = append(to, string(rune('0'+j)))
+			fromBytes = append(fromBytes, old)
+			toBytes = append(toBytes, []byte(to[len(to)-1]))
+		}
+		table := newReplaceTable(fromBytes, toBytes)
+		for _, text := range texts[7:] {
+			out, replaced := table.replace(nil, []byte(text), nil)
+			if replaced == 0 {
+				out = []byte(text)
+			}
+			checkString(t, "replacing "+text, replaceSlowly(from, to, text), string(out))
+		}
+	}
+}
+
+func TestMCommand(t *testing.T) {
+	dir := t.TempDir()
+	table := filepath.Join(dir, "map.tsv")
+	if err := os.WriteFile(table, []byte("oldName\tnewName\noldNameSpace\tnewNameSpace\r\n\nx\t\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	checkString(t, "m replaces every old text", "newName newNameSpace y\n", runSed(t, "m "+table, "oldName oldNameSpace xyx\n"))
+	checkString(t, "m takes an address", "oldName\nnewName\n", runSed(t, "2m "+table, "oldName\noldName\n"))
+	checkString(t, "t branches after m", "yes\nno\n", runSed(t, "m "+table+"\nt yes\nc\\\nno\nb\n:yes\nc\\\nyes", "x\nz\n"))
+
+	bad := filepath.Join(dir, "bad.tsv")
+	if err := os.WriteFile(bad, []byte("fine\tline\nno tab\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for script, expected := range map[string]error{
+		"m " + bad:                               ErrReplaceTableLine,
+		"m":                                      ErrMissingFileName,
+		"m " + filepath.Join(dir, "missing.tsv"): os.ErrNotExist,
+	} {
+		s := new(Sed)
+		s.Init()
+		if err := s.parseScript([]byte(script)); !errors.Is(err, expected) {
+			t.Errorf("%q: expected %v, got %v", script, expected, err)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// walk.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+
+// Package sed implements the entire program, from this specific part, we implement -R, which replaces the directories among the operands with the files below them
+package sed
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// binarySniffLength is how much of a file is looked at for a NUL byte, which makes it binary. Git looks at as much.
+const binarySniffLength = 8000
+
+// expandOperands replaces the directories among operands with the files below them, in lexical order. Version
+// control directories, binary files, symbolic links, files not matching --include, anything matching --exclude
+// and, with --gitignore, what the .gitignore files inside the tree ignore are left out. Operands that aren't
+// directories are kept as they are.
+func expandOperands(operands []string) ([]string, error) {
+	var files []string
+	for _, operand := range operands {
+		info, err := os.Stat(operand)
+		if err != nil || !info.IsDir() {
+			files = append(files, operand)
+			continue
+		}
+		found, err := walkTree(operand)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, found...)
+	}
+	return files, nil
+}
+
+// walkTree returns the files below root that -R edits, see expandOperands.
+func walkTree(root string) ([]string, error) {
+	root = filepath.Clean(root)
+	var files []string
+	ignores := make(map[string][]ignorePattern) // the patterns of the .gitignore of each directory, by its path
+	err := filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Like grep -r, a directory that can't be read doesn't stop the rest of the tree
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			return nil
+		}
+		if name != root && skipEntry(root, name, d, ignores) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if *gitignore {
+				patterns, err := readGitignore(filepath.Join(name, ".gitignore"))
+				if err != nil {
+					return err
+				}
+				ignores[name] = patterns
+			}
+			return nil
+		}
+		binary, err := isBinary(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			return nil
+		}
+		if !binary {
+			files = append(files, name)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// skipEntry reports whether the walk of root leaves out name, before looking inside it.
+func skipEntry(root, name string, d fs.DirEntry, ignores map[string][]ignorePattern) bool {
+	if d.IsDir() && d.Name() == ".git" {
+		return true
+	}
+	if !d.IsDir() && !d.Type().IsRegular() {
+		return true
+	}
+	rel, err := filepath.Rel(root, name)
+	if err != nil {
+		return true
+	}
+	rel = filepath.ToSlash(rel)
+	if matchGlobs(excludeGlobs, rel) {
+		return true
+	}
+	if !d.IsDir() && len(includeGlobs) > 0 && !matchGlobs(includeGlobs, rel) {
+		return true
+	}
+	return ignored(root, name, d.IsDir(), ignores)
+}
+
+// matchGlobs reports whether any of globs matches the path rel, relative to the directory being walked. Globs
+// without a slash are matched against the last element of rel, the others against all of it.
+func matchGlobs(globs []string, rel string) bool {
+	for _, glob := range globs {
+		name := rel
+		if !strings.Contains(glob, "/") {
+			name = path.Base(rel)
+		}
+		if ok, _ := path.Match(glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ignored reports whether the .gitignore files of the directories from root down to the one of name ignore it.
+// Like git, the last pattern matching decides, and the deeper .gitignore files come last.
+func ignored(root, name string, isDir bool, ignores map[string][]ignorePattern) bool {
+	if len(ignores) == 0 {
+		return false
+	}
+	var dirs []string
+	for dir := filepath.Dir(name); ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == root || dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	result := false
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rel, err := filepath.Rel(dirs[i], name)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		for _, p := range ignores[dirs[i]] {
+			if p.match(rel, isDir) {
+				result = !p.negate
+			}
+		}
+	}
+	return result
+}
+
+// isBinary reports whether the file name looks binary, by the NUL bytes at its start.
+func isBinary(name string) (bool, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	buf := make([]byte, binarySniffLength)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	return bytes.IndexByte(buf[:n], 0) >= 0, nil
+}
+
+// ignorePattern is a line of a .gitignore file.
+type ignorePattern struct {
+	segments []string // the pattern split on '/', where "**" stands for any number of directories
+	negate   bool     // the pattern started with '!', what it matches is not ignored after all
+	dirOnly  bool     // the pattern ended with '/', it only matches directories
+	anchored bool     // the pattern had a '/' before its end, it is matched against the whole path from its directory
+}
+
+// readGitignore reads the patterns of a .gitignore file, none when there is no such file.
+func readGitignore(name string) ([]ignorePattern, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return parseGitignore(data), nil
+}
+
+// parseGitignore parses the lines of a .gitignore file, skipping blank lines and comments.
+func parseGitignore(data []byte) []ignorePattern {
+	var patterns []ignorePattern
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if !strings.HasSuffix(line, "\\ ") {
+			line = strings.TrimRight(line, " ")
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		var p ignorePattern
+		if line[0] == '!' {
+			p.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			p.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		p.segments = strings.Split(line, "/")
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// match reports whether p matches the path rel, relative to the directory of its .gitignore file.
+func (p ignorePattern) match(rel string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	parts := strings.Split(rel, "/")
+	if !p.anchored {
+		return matchSegments(p.segments, parts[len(parts)-1:])
+	}
+	return matchSegments(p.segments, parts)
+}
+
+// matchSegments matches the elements of a path against the segments of a pattern, one by one, "**" matching any
+// number of them.
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
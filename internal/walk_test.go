@@ -0,0 +1,76 @@
+// walk_test.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+package sed
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandOperands(t *testing.T) {
+	defer func() {
+		*gitignore = false
+		includeGlobs, excludeGlobs = nil, nil
+	}()
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"main.go":             "package main\n",
+		"README.md":           "# readme\n",
+		"logo.png":            "\x89PNG\r\n\x1a\n\x00\x00",
+		".git/config":         "[core]\n",
+		".gitignore":          "*.log\n/build/\n!keep.log\n",
+		"build/out.go":        "package out\n",
+		"app.log":             "log\n",
+		"keep.log":            "kept\n",
+		"src/build/gen.go":    "package gen\n",
+		"src/build/api.go":    "package api\n",
+		"src/lib.go":          "package lib\n",
+		"src/.gitignore":      "gen*\ndocs/**/*.md\n",
+		"src/docs/a/b/x.md":   "# x\n",
+		"src/docs/y.txt":      "y\n",
+		"vendor/dep/dep.go":   "package dep\n",
+		"vendor/dep/notes.md": "notes\n",
+	} {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "main.go"), filepath.Join(dir, "link.go")); err != nil {
+		t.Fatal(err)
+	}
+
+	expand := func(operands ...string) string {
+		files, err := expandOperands(operands)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, file := range files {
+			if rel, err := filepath.Rel(dir, file); err == nil {
+				files[i] = filepath.ToSlash(rel)
+			}
+		}
+		return strings.Join(files, " ")
+	}
+
+	checkString(t, "everything but .git, binaries and links", ".gitignore README.md app.log build/out.go keep.log main.go src/.gitignore src/build/api.go src/build/gen.go src/docs/a/b/x.md src/docs/y.txt src/lib.go vendor/dep/dep.go vendor/dep/notes.md", expand(dir))
+	checkString(t, "files are kept", "missing main.go", expand("missing", filepath.Join(dir, "main.go")))
+
+	*gitignore = true
+	checkString(t, "with --gitignore", ".gitignore README.md keep.log main.go src/.gitignore src/build/api.go src/docs/y.txt src/lib.go vendor/dep/dep.go vendor/dep/notes.md", expand(dir))
+
+	includeGlobs, excludeGlobs = globsFlag{"*.go"}, globsFlag{"vendor"}
+	checkString(t, "with --include and --exclude", "main.go src/build/api.go src/lib.go", expand(dir))
+	includeGlobs, excludeGlobs = globsFlag{"src/*.go", "*.md"}, nil
+	checkString(t, "globs with a slash match the path", "README.md src/lib.go vendor/dep/notes.md", expand(dir))
+}
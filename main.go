@@ -6,6 +6,18 @@
 // For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
 package main
 
-import "gosed/internal"
+import (
+	_ "embed"
 
-func main() { sed.Main() }
+	"github.com/xplshn/gosed/internal"
+)
+
+// manual is the POSIX manual page of sed, the documentation gosed lsp shows
+//
+//go:embed sed.html
+var manual []byte
+
+func main() {
+	sed.Manual = manual
+	sed.Main()
+}
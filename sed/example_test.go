@@ -0,0 +1,123 @@
+// example_test.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+package sed_test
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xplshn/gosed/sed"
+)
+
+func ExampleNew() {
+	// A script starting with #n only silences itself
+	quiet, err := sed.New([]byte("#n\n/b/p"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := quiet.Run(strings.NewReader("a\nb\n"), os.Stdout); err != nil {
+		fmt.Println(err)
+	}
+	s, err := sed.New([]byte("s/a/A/"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := s.Run(strings.NewReader("a\nb\n"), os.Stdout); err != nil {
+		fmt.Println(err)
+	}
+	// Output:
+	// b
+	// A
+	// b
+}
+
+func ExampleRegisterCommand() {
+	secret := regexp.MustCompile(`[0-9a-f]{8,}`)
+	err := sed.RegisterCommand(sed.CommandDef{
+		Name: "redact",
+		Exec: func(ctx *sed.ExecContext, _ any) error {
+			ctx.SetPatternSpace(secret.ReplaceAll(ctx.PatternSpace(), []byte("***")))
+			return nil
+		},
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	s, err := sed.New([]byte("/token/redact"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := s.Run(strings.NewReader("user=alice\ntoken=8f3a9c0d12e4\n"), os.Stdout); err != nil {
+		fmt.Println(err)
+	}
+	// Output:
+	// user=alice
+	// token=***
+}
+
+func ExampleRegisterAddress() {
+	// @every{N} selects every Nth line
+	err := sed.RegisterAddress("every", func(arg string) (sed.Address, error) {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("@every needs a positive number, not %q", arg)
+		}
+		return sed.AddressFunc(func(ctx *sed.ExecContext) bool { return ctx.LineNumber()%n == 0 }), nil
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	s, err := sed.New([]byte("@every{2}s/^/> /"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := s.Run(strings.NewReader("a\nb\nc\nd\n"), os.Stdout); err != nil {
+		fmt.Println(err)
+	}
+	// Output:
+	// a
+	// > b
+	// c
+	// > d
+}
+
+// branches counts the branches each b and t command of a script takes.
+type branches struct {
+	sed.NopObserver
+	taken map[string]int
+}
+
+func (b *branches) Branch(_ *sed.ExecContext, cmd sed.CommandInfo, _ string) {
+	b.taken[cmd.Source]++
+}
+
+func ExampleSed_AddObserver() {
+	s, err := sed.New([]byte(":top\ns/ab/ba/\nt top"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	b := &branches{taken: make(map[string]int)}
+	s.AddObserver(b)
+	if err := s.Run(strings.NewReader("aab\nb\n"), os.Stdout); err != nil {
+		fmt.Println(err)
+	}
+	fmt.Println(b.taken)
+	// Output:
+	// baa
+	// b
+	// map[t top:2]
+}
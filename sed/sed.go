@@ -0,0 +1,72 @@
+// sed.go
+// sed
+//
+// Original code: Copyright (c) 2009 Geoffrey Clements (MIT License)
+// Modified code: Copyright (c) 2024 xplshn (3BSD License)
+// For details, see the [LICENSE](https://github.com/xplshn/gosed) file at the root directory of this project
+
+// Package sed lets Go programs run sed scripts with gosed and give them commands of their own. The engine itself
+// lives in an internal package, this one only exposes what is meant to be used from outside the module.
+package sed
+
+import (
+	engine "github.com/xplshn/gosed/internal"
+)
+
+// Sed runs a parsed script, see New.
+type Sed = engine.Sed
+
+// ExecContext is what a registered command works on: the pattern and hold space, the line number and the output of
+// the current cycle.
+type ExecContext = engine.ExecContext
+
+// CommandDef defines a command registered with RegisterCommand.
+type CommandDef = engine.CommandDef
+
+// Address selects the lines of an address registered with RegisterAddress.
+type Address = engine.Address
+
+// AddressFunc lets an ordinary function be an Address.
+type AddressFunc = engine.AddressFunc
+
+// Observer is told what a run of the script does, once it is added with the AddObserver method of Sed.
+type Observer = engine.Observer
+
+// NopObserver is an Observer that does nothing, to be embedded by those that only want some of the calls.
+type NopObserver = engine.NopObserver
+
+// CommandInfo describes a command of the script to an Observer.
+type CommandInfo = engine.CommandInfo
+
+// NoAddresses is the MaxAddresses of a registered command that takes no address, like : or }.
+const NoAddresses = engine.NoAddresses
+
+// Errors of RegisterCommand and RegisterAddress, and of parsing a registered command
+var (
+	ErrBadCommandName        = engine.ErrBadCommandName
+	ErrCommandRegistered     = engine.ErrCommandRegistered
+	ErrMissingCommandExec    = engine.ErrMissingCommandExec
+	ErrBadCommandAddresses   = engine.ErrBadCommandAddresses
+	ErrUnexpectedCommandText = engine.ErrUnexpectedCommandText
+	ErrBadAddressName        = engine.ErrBadAddressName
+	ErrAddressRegistered     = engine.ErrAddressRegistered
+)
+
+// New returns a Sed ready to Run script, which writes the pattern space at the end of each cycle like sed without -n
+// unless the script starts with #n. Every Sed has a state of its own, New can be called from several goroutines.
+func New(script []byte) (*Sed, error) {
+	return engine.New(script)
+}
+
+// RegisterCommand adds the command def to the ones every script can use. It is meant to be called before the script
+// is parsed, from an init function or at the start of main, and isn't safe to call concurrently.
+func RegisterCommand(def CommandDef) error {
+	return engine.RegisterCommand(def)
+}
+
+// RegisterAddress lets scripts select lines with @name, or @name{argument}, for the Address parse returns for the
+// argument ("" without one) when the script is parsed. Like RegisterCommand, it is meant to be called before the
+// script is parsed and isn't safe to call concurrently.
+func RegisterAddress(name string, parse func(arg string) (Address, error)) error {
+	return engine.RegisterAddress(name, parse)
+}